@@ -0,0 +1,253 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// The body expected by ChangePasswordHandler, either as a JSON object or as form fields with the same names.
+type PasswordChange struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// The body expected by ChangeUsernameHandler, either as a JSON object or as form fields with the same names.
+type UsernameChange struct {
+	Username        string `json:"username"`
+	CurrentPassword string `json:"current_password"`
+}
+
+// The body expected by DeleteAccountHandler, either as a JSON object or as form fields with the same names.
+type AccountDeletion struct {
+	CurrentPassword string `json:"current_password"`
+}
+
+// Handles changing the password of the logged-in user, which also logs out all of their other sessions. It must be
+// used behind Authmiddleware.
+//
+// The expected request to this endpoint is a JSON object with the form:
+//
+// { "current_password" : "PASSWORD", "new_password" : "PASSWORD" }
+//
+// or a form post with the same fields. The new password has to meet the AuthContext's Policy. On success it responds
+// with a MessageResponse, otherwise with an ErrorResponse.
+func (ac *AuthContext) ChangePasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var req PasswordChange
+	err := decodeRequest(w, r, &req, func(get func(string) string) {
+		req.CurrentPassword = get("current_password")
+		req.NewPassword = get("new_password")
+	})
+	if err != nil {
+		writeError(w, requestDecodeError(err))
+		return
+	}
+	if req.CurrentPassword == "" || req.NewPassword == "" {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidRequest, "The current and new password are required")
+		return
+	}
+
+	u, ok := ac.confirmPassword(w, r, req.CurrentPassword)
+	if !ok {
+		return
+	}
+
+	if ac.Policy != nil {
+		if fieldErrs := ac.Policy.ValidatePassword(req.NewPassword, u.Username); len(fieldErrs) > 0 {
+			writeError(w, &apiError{
+				Status:  http.StatusUnprocessableEntity,
+				Code:    ErrCodeValidationFailed,
+				Message: "The new password does not meet the requirements",
+				Fields:  fieldErrs,
+			})
+			return
+		}
+	}
+
+	hashedPassword, err := ac.Hasher.Hash(req.NewPassword)
+	if err != nil {
+		log.Printf("Error hashing password: %s", err)
+		writeError(w, internalError)
+		return
+	}
+	u.HashedPassword = hashedPassword
+	if err := ac.Users.UpdateUser(r.Context(), u); err != nil {
+		log.Printf("Error updating password for user %s: %s", u.UserId, err)
+		writeError(w, internalError)
+		return
+	}
+
+	// whoever else knew the old password may still be logged in, so log out every other session
+	current, _ := SessionFromContext(r.Context())
+	if err := ac.Sessions.DeleteSessionsByUserId(r.Context(), u.UserId, string(current.Id)); err != nil {
+		log.Printf("Error revoking other sessions of user %s after a password change: %s", u.UserId, err)
+		writeError(w, internalError)
+		return
+	}
+	writeJSON(w, http.StatusOK, MessageResponse{Message: "Password changed"})
+}
+
+// Handles changing the username of the logged-in user. It must be used behind Authmiddleware.
+//
+// The expected request to this endpoint is a JSON object with the form:
+//
+// { "username" : "VALUE", "current_password" : "PASSWORD" }
+//
+// or a form post with the same fields. The new username is normalized and validated by the AuthContext's Policy
+// like at registration, and is rejected if another user has it. On success it responds with a UserResponse,
+// otherwise with an ErrorResponse.
+func (ac *AuthContext) ChangeUsernameHandler(w http.ResponseWriter, r *http.Request) {
+	var req UsernameChange
+	err := decodeRequest(w, r, &req, func(get func(string) string) {
+		req.Username = get("username")
+		req.CurrentPassword = get("current_password")
+	})
+	if err != nil {
+		writeError(w, requestDecodeError(err))
+		return
+	}
+	if req.Username == "" || req.CurrentPassword == "" {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidRequest, "A username and the current password are required")
+		return
+	}
+
+	u, ok := ac.confirmPassword(w, r, req.CurrentPassword)
+	if !ok {
+		return
+	}
+
+	submitted := req.Username
+	if ac.Policy != nil {
+		req.Username = ac.Policy.NormalizeUsername(req.Username)
+		if fieldErrs := ac.Policy.ValidateUsername(req.Username); len(fieldErrs) > 0 {
+			writeError(w, &apiError{
+				Status:  http.StatusUnprocessableEntity,
+				Code:    ErrCodeValidationFailed,
+				Message: "The username does not meet the requirements",
+				Fields:  fieldErrs,
+			})
+			return
+		}
+	}
+
+	existing, err := ac.loadUsernameOwner(r.Context(), submitted, req.Username)
+	if errors.Is(err, sessions.ErrUserNotFound) {
+		// proceed
+	} else if err != nil {
+		log.Printf("Error looking up users to ensure unique username: %s", err)
+		writeError(w, internalError)
+		return
+	} else if existing.UserId != u.UserId {
+		writeErrorCode(w, http.StatusConflict, ErrCodeUsernameTaken, "Username already taken")
+		return
+	}
+
+	u.Username = req.Username
+	if err := ac.Users.UpdateUser(r.Context(), u); err != nil {
+		log.Printf("Error updating username for user %s: %s", u.UserId, err)
+		writeError(w, internalError)
+		return
+	}
+	writeJSON(w, http.StatusOK, UserResponse{UserId: u.UserId, Username: u.Username})
+}
+
+// Handles deleting the account of the logged-in user along with all of their sessions, and clears the session
+// cookie. It must be used behind Authmiddleware.
+//
+// The expected request to this endpoint is a JSON object with the form:
+//
+// { "current_password" : "PASSWORD" }
+//
+// or a form post with the same field. On success it responds with a MessageResponse, otherwise with an
+// ErrorResponse.
+func (ac *AuthContext) DeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	var req AccountDeletion
+	err := decodeRequest(w, r, &req, func(get func(string) string) {
+		req.CurrentPassword = get("current_password")
+	})
+	if err != nil {
+		writeError(w, requestDecodeError(err))
+		return
+	}
+	if req.CurrentPassword == "" {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidRequest, "The current password is required")
+		return
+	}
+
+	u, ok := ac.confirmPassword(w, r, req.CurrentPassword)
+	if !ok {
+		return
+	}
+
+	// the sessions go first, since they may be kept in a separate store that deleting the user doesn't reach
+	if err := ac.Sessions.DeleteSessionsByUserId(r.Context(), u.UserId, ""); err != nil {
+		log.Printf("Error deleting sessions of user %s: %s", u.UserId, err)
+		writeError(w, internalError)
+		return
+	}
+	if err := ac.Users.DeleteUserByUserId(r.Context(), u.UserId); err != nil {
+		log.Printf("Error deleting user %s: %s", u.UserId, err)
+		writeError(w, internalError)
+		return
+	}
+
+	http.SetCookie(w, sessions.LogoutHandler(ac.Cookie, ac.cookieOptions()...))
+	ac.setCSRFCookie(w, "")
+	writeJSON(w, http.StatusOK, MessageResponse{Message: "Account deleted"})
+}
+
+// Loads the authenticated user and checks that they sent their current password, so that someone who gets hold of
+// a session cannot take over the account with it. Wrong passwords count against the username's login throttling.
+// If the check fails an error response is written and false is returned.
+func (ac *AuthContext) confirmPassword(w http.ResponseWriter, r *http.Request, password string) (sessions.User, bool) {
+	u, err := UserFromContext(r.Context())
+	if errors.Is(err, ErrNoSessionInContext) {
+		writeErrorCode(w, http.StatusUnauthorized, ErrCodeNotAuthenticated, "Not authenticated")
+		return u, false
+	}
+	if errors.Is(err, sessions.ErrUserNotFound) {
+		writeErrorCode(w, http.StatusUnauthorized, ErrCodeInvalidSession, "The user for this session no longer exists")
+		return u, false
+	}
+	if err != nil {
+		log.Printf("Error loading user: %s", err)
+		writeError(w, internalError)
+		return u, false
+	}
+
+	var limiterKeys []string
+	if ac.Limiter != nil {
+		username := u.Username
+		if ac.Policy != nil {
+			username = ac.Policy.NormalizeUsername(username)
+		}
+		limiterKeys = []string{usernameLimiterKey(username)}
+		wait, err := ac.allowLogin(r.Context(), limiterKeys)
+		if err != nil {
+			log.Printf("Error checking password rate limit for user %s: %s", u.Username, err)
+		} else if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeErrorCode(w, http.StatusTooManyRequests, ErrCodeRateLimited, "Too many attempts, try again later")
+			return u, false
+		}
+	}
+
+	ok, _, err := ac.verifyPassword(password, u.HashedPassword)
+	if err != nil {
+		log.Printf("Error verifying password for user %s: %s", u.UserId, err)
+	}
+	if !ok || err != nil {
+		for _, key := range limiterKeys {
+			if err := ac.Limiter.Failure(r.Context(), key); err != nil {
+				log.Printf("Error recording failed password check for %s: %s", key, err)
+			}
+		}
+		writeErrorCode(w, http.StatusUnauthorized, ErrCodeInvalidCredentials, "The current password is incorrect")
+		return u, false
+	}
+	return u, true
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+func TestAccountHandlers(t *testing.T) {
+	hasher := &slowHasher{}
+	store := NewMemoryAuthStore()
+	store.SaveUser(t.Context(), sessions.User{UserId: "01", Username: "alice", HashedPassword: "$slow$correct horse battery"})
+	store.SaveUser(t.Context(), sessions.User{UserId: "02", Username: "bob", HashedPassword: "$slow$tr0ub4dor&3 staple"})
+	ac := NewAuthContext(store, sessions.NewKeyringFromSecret("test signing secret"), time.Hour, WithPasswordHasher(hasher))
+
+	cookie, sessionId := sessions.NewCookieWithSessionId(ac.Keys, time.Hour, ac.Cookie)
+	store.SaveSession(t.Context(), sessions.Session{Id: sessions.SessionId(sessionId), UserId: "01", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)})
+
+	serve := func(handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		ac.Authmiddleware(handler).ServeHTTP(w, r)
+		return w
+	}
+
+	if w := serve(ac.ChangePasswordHandler, `{"current_password":"wrong","new_password":"a much better passphrase"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("password change with the wrong current password returned %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := serve(ac.ChangePasswordHandler, `{"current_password":"correct horse battery","new_password":"a much better passphrase"}`); w.Code != http.StatusOK {
+		t.Errorf("password change returned %d: %s", w.Code, w.Body)
+	}
+	if u, _ := store.LoadUserByUserId(t.Context(), "01"); u.HashedPassword != "$slow$a much better passphrase" {
+		t.Errorf("stored hash after password change = %q", u.HashedPassword)
+	}
+
+	if w := serve(ac.ChangeUsernameHandler, `{"username":"Bob","current_password":"a much better passphrase"}`); w.Code != http.StatusConflict {
+		t.Errorf("changing to a taken username returned %d, want %d", w.Code, http.StatusConflict)
+	}
+	if w := serve(ac.ChangeUsernameHandler, `{"username":"Alicia","current_password":"a much better passphrase"}`); w.Code != http.StatusOK {
+		t.Errorf("username change returned %d: %s", w.Code, w.Body)
+	}
+	if _, err := store.LoadUserByUsername(t.Context(), "alicia"); err != nil {
+		t.Errorf("loading the user by the new normalized username: %s", err)
+	}
+
+	if w := serve(ac.DeleteAccountHandler, `{"current_password":"a much better passphrase"}`); w.Code != http.StatusOK {
+		t.Errorf("account deletion returned %d: %s", w.Code, w.Body)
+	}
+	if _, err := store.LoadUserByUserId(t.Context(), "01"); err != sessions.ErrUserNotFound {
+		t.Errorf("loading the deleted user returned %v, want %v", err, sessions.ErrUserNotFound)
+	}
+	if _, err := store.LoadSessionById(t.Context(), sessionId); err != sessions.ErrSessionNotFound {
+		t.Errorf("loading the deleted user's session returned %v, want %v", err, sessions.ErrSessionNotFound)
+	}
+	if w := serve(ac.DeleteAccountHandler, `{"current_password":"a much better passphrase"}`); w.Code != http.StatusUnauthorized {
+		t.Errorf("request with the deleted user's session returned %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestSeparateUserAndSessionStores(t *testing.T) {
+	users := NewMemoryAuthStore()
+	sessionStore := NewMemoryAuthStore()
+	users.SaveUser(t.Context(), sessions.User{UserId: "01", Username: "alice", HashedPassword: "$slow$correct horse battery"})
+	// the user store is given only as a UserStore, so it can't be used for sessions by mistake
+	ac := NewAuthContextWithStores(struct{ sessions.UserStore }{users}, sessionStore, sessions.NewKeyringFromSecret("test signing secret"),
+		time.Hour, WithPasswordHasher(&slowHasher{}), WithSessionReaper(time.Hour, 10))
+	defer ac.Close()
+	if ac.Reaper.Store != sessionStore {
+		t.Error("the reaper does not work on the session store")
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"alice","password":"correct horse battery"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ac.LoginHandler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("login returned %d: %s", w.Code, w.Body)
+	}
+	if list, _ := sessionStore.ListSessionsByUserId(t.Context(), "01"); len(list) != 1 {
+		t.Errorf("the session store has %d sessions after login, want 1", len(list))
+	}
+	if list, _ := users.ListSessionsByUserId(t.Context(), "01"); len(list) != 0 {
+		t.Errorf("the user store has %d sessions after login, want 0", len(list))
+	}
+
+	r = httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"current_password":"correct horse battery"}`))
+	r.Header.Set("Content-Type", "application/json")
+	for _, c := range w.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	w = httptest.NewRecorder()
+	ac.Authmiddleware(http.HandlerFunc(ac.DeleteAccountHandler)).ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("account deletion returned %d: %s", w.Code, w.Body)
+	}
+	if list, _ := sessionStore.ListSessionsByUserId(t.Context(), "01"); len(list) != 0 {
+		t.Errorf("the session store has %d sessions after account deletion, want 0", len(list))
+	}
+}
@@ -2,264 +2,489 @@ package auth
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
-	"fmt"
 	"github.com/cameronmore/go-sessions/sessions"
-	"github.com/oklog/ulid/v2"
-	"golang.org/x/crypto/bcrypt"
-	"io"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 )
 
 // An authentication manager that handles creating, accessing, and deleting sessions.
 type AuthContext struct {
-	Ac       sessions.AuthStore
-	Secret   string
+	// Where users and sessions are kept. They are the same store unless changed with WithUserStore or
+	// WithSessionStore.
+	Users    sessions.UserStore
+	Sessions sessions.SessionStore
+	Keys     *sessions.Keyring
+	// Settings for the session cookie, sessions.DefaultCookieConfig() unless changed with WithCookieConfig.
+	Cookie sessions.CookieConfig
+	// Decides which usernames and passwords are accepted at registration, DefaultPolicy() unless changed with
+	// WithPolicy. A nil policy accepts any non-empty username and password as-is.
+	Policy Policy
+	// Hashes new passwords. Stored hashes made by one of the LegacyHashers, or by this hasher with weaker
+	// parameters, are upgraded the next time their user logs in. Defaults to argon2id, with bcrypt as the legacy
+	// hasher so that accounts created before argon2id was the default can still log in.
+	Hasher        PasswordHasher
+	LegacyHashers []PasswordHasher
+	// The idle timeout of a session: how long a session stays valid after it was created or last renewed.
 	Duration time.Duration
+	// The absolute lifetime of a session measured from its creation, after which it expires no matter how active
+	// the user is. Zero means sessions can be renewed indefinitely.
+	MaxLifetime time.Duration
+	// The fraction of Duration that has to elapse since a session was created or last renewed before the
+	// middleware extends it. Zero disables renewal.
+	RenewAfter float64
+	// Throttles login attempts per username and per client IP address. Nil, the default, disables throttling.
+	Limiter LoginLimiter
+	// Returns the IP address a request came from, used to throttle logins per client. Defaults to RemoteAddrIP.
+	ClientIP func(*http.Request) string
+	// Settings for CSRFMiddleware, DefaultCSRFConfig() unless changed with WithCSRFConfig.
+	CSRF CSRFConfig
+	// Deletes expired sessions from the store in the background. Nil unless set with WithSessionReaper, in which
+	// case it is started by NewAuthContext and stopped by Close.
+	Reaper *Reaper
+	// Tells the time when sessions are created, checked for expiry and renewed. sessions.SystemClock{} unless
+	// changed with WithClock.
+	Clock sessions.Clock
+	// Make the ids of new sessions and users, sessions.UUIDv4 and sessions.ULID unless changed with
+	// WithSessionIdGenerator or WithUserIdGenerator.
+	SessionIds sessions.IdGenerator
+	UserIds    sessions.IdGenerator
+
+	// checked at login in place of the hash of a username that doesn't exist, by dummyHasher, which
+	// WithDummyPasswordHasher can pin before NewAuthContext picks one
+	dummyHash   string
+	dummyHasher PasswordHasher
 }
 
-// Returns a new Authcontext authentication manager given a secret string used for cookie signing and a db connection.
-func NewAuthContext(authStore sessions.AuthStore, secret string, d time.Duration) *AuthContext {
-	return &AuthContext{
-		Ac:       authStore,
-		Secret:   secret,
-		Duration: d,
+// Returns a new Authcontext authentication manager given a keyring used for cookie signing and a store for users and
+// sessions. To keep sessions somewhere else than users, use NewAuthContextWithStores.
+//
+// To keep using a single secret string, pass sessions.NewKeyringFromSecret(secret).
+func NewAuthContext(authStore sessions.AuthStore, keys *sessions.Keyring, d time.Duration, opts ...Option) *AuthContext {
+	return NewAuthContextWithStores(authStore, authStore, keys, d, opts...)
+}
+
+// Returns a new Authcontext like NewAuthContext that keeps users and sessions in separate stores, for example users
+// in Postgres and sessions in Redis. Neither store has to implement the other's interface.
+func NewAuthContextWithStores(users sessions.UserStore, sessionStore sessions.SessionStore, keys *sessions.Keyring, d time.Duration, opts ...Option) *AuthContext {
+	ac := &AuthContext{
+		Users:         users,
+		Sessions:      sessionStore,
+		Keys:          keys,
+		Cookie:        sessions.DefaultCookieConfig(),
+		Policy:        DefaultPolicy(),
+		Hasher:        NewArgon2idHasher(),
+		LegacyHashers: []PasswordHasher{NewBcryptHasher()},
+		Duration:      d,
+		RenewAfter:    defaultRenewAfter,
+		ClientIP:      RemoteAddrIP,
+		CSRF:          DefaultCSRFConfig(),
+		Clock:         sessions.SystemClock{},
+		SessionIds:    sessions.UUIDv4,
+		UserIds:       sessions.ULID,
+	}
+	for _, opt := range opts {
+		opt(ac)
+	}
+	ac.dummyHasher, ac.dummyHash = newDummyPasswordHash(ac.dummyHasher, ac.Hasher, ac.LegacyHashers)
+	if ac.Reaper != nil {
+		if ac.Reaper.Store == nil {
+			ac.Reaper.Store = ac.Sessions
+		}
+		if ac.Reaper.Clock == nil {
+			ac.Reaper.Clock = ac.Clock
+		}
+		if limiter, ok := ac.Limiter.(*StoreLimiter); ok && ac.Reaper.Attempts == nil {
+			if sweeper, ok := limiter.Store.(sessions.LoginAttemptSweeper); ok {
+				ac.Reaper.Attempts = sweeper
+				ac.Reaper.AttemptRetention = limiter.Config.retention()
+			}
+		}
+		ac.Reaper.Start(context.Background())
 	}
+	return ac
 }
 
-// Handles the registration of new users and returns errors to the client if a username is already taken or the username does not meet some basic criteria.
+// Stops the background work owned by the AuthContext, waiting for a running Reaper pass to finish.
+func (ac *AuthContext) Close() {
+	if ac.Reaper != nil {
+		ac.Reaper.Stop()
+	}
+}
+
+// Handles the registration of new users and returns errors to the client if a username is already taken or the
+// username or password does not meet the AuthContext's Policy. Usernames are stored in the form the policy
+// normalizes them to.
 //
 // The expected request to this endpoint is a JSON object with the form:
 //
 // { "username" : "VALUE", "password" : "PASSWORD" }
+//
+// or a form post (application/x-www-form-urlencoded or multipart/form-data) with username and password fields. On
+// success it responds with 201 and a UserResponse, otherwise with an ErrorResponse.
 func (ac *AuthContext) RegisterHandler(w http.ResponseWriter, r *http.Request) {
-	var formData map[string]interface{}
-	bodyData, err := io.ReadAll(r.Body)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	creds, apiErr := decodeCredentials(w, r)
+	if apiErr != nil {
+		writeError(w, apiErr)
 		return
 	}
-	err = json.Unmarshal(bodyData, &formData)
-	if err != nil {
-		panic(err)
-	}
 
-	hashedPassword, err := hash(formData["password"].(string))
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+	submitted := creds.Username
+	if ac.Policy != nil {
+		creds.Username = ac.Policy.NormalizeUsername(creds.Username)
+		fieldErrs := append(ac.Policy.ValidateUsername(creds.Username), ac.Policy.ValidatePassword(creds.Password, creds.Username)...)
+		if len(fieldErrs) > 0 {
+			writeError(w, &apiError{
+				Status:  http.StatusUnprocessableEntity,
+				Code:    ErrCodeValidationFailed,
+				Message: "The username or password does not meet the requirements",
+				Fields:  fieldErrs,
+			})
+			return
+		}
 	}
 
 	// look up the username, handle internal db server errors, and return an error
 	// if the username is already taken
-	_, err = ac.Ac.LoadUserByUsername(formData["username"].(string), r.Context())
+	_, err := ac.loadUsernameOwner(r.Context(), submitted, creds.Username)
 	if errors.Is(err, sessions.ErrUserNotFound) {
 		// proceed
-	} else if err != nil && !errors.Is(err, sessions.ErrUserNotFound) {
-		w.WriteHeader(http.StatusInternalServerError)
+	} else if err != nil {
 		log.Printf("Error looking up users to ensure unique username: %s", err)
+		writeError(w, internalError)
+		return
+	} else {
+		writeErrorCode(w, http.StatusConflict, ErrCodeUsernameTaken, "Username already taken")
 		return
-	} else if err == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Username already taken"))
+	}
+
+	hashedPassword, err := ac.Hasher.Hash(creds.Password)
+	if err != nil {
+		log.Printf("Error hashing password: %s", err)
+		writeError(w, internalError)
 		return
 	}
 
 	// add user to DB
 	var newUser sessions.User
-	newUser.UserId = ulid.Make().String()
-	newUser.Username = formData["username"].(string)
+	newUser.UserId = ac.UserIds()
+	newUser.Username = creds.Username
 	newUser.HashedPassword = hashedPassword
-	err = ac.Ac.SaveUser(newUser)
+	err = ac.Users.SaveUser(r.Context(), newUser)
 	if err != nil {
 		// log it out
 		log.Printf("Error inserting user into DB: %s", err.Error())
-		// better error handling is needed here for when usernames
-		// don't follow certain rules or are not unique
-		// but for now, this works
-		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, internalError)
 		return
 	}
 
-	sessionId, cookie := sessions.RegisterHandler(ac.Secret, ac.Duration)
-	var nSession sessions.Session
-	nSession.Id = sessions.SessionId(sessionId)
-	nSession.ExpiresAt = cookie.Expires
-	nSession.UserId = formData["username"].(string)
-	err = ac.Ac.SaveSession(nSession)
-	if err != nil {
-		// log it out
-		log.Printf("Error inserting session into DB: %s", err.Error())
-		// better error handling is needed here for when usernames
-		// don't follow certain rules or are not unique
-		// but for now, this works
-		w.WriteHeader(http.StatusInternalServerError)
+	if !ac.startSession(w, r, newUser.UserId) {
 		return
 	}
-
-	http.SetCookie(w, cookie)
-	w.WriteHeader(http.StatusCreated)
-	w.Write([]byte("User created"))
+	writeJSON(w, http.StatusCreated, UserResponse{UserId: newUser.UserId, Username: newUser.Username})
 }
 
-// Handles the login for users, returning an error if the user does not exist or the password is incorrect.
+// Handles the login for users, returning an error if the user does not exist or the password is incorrect. When a
+// Limiter is configured, throttled attempts are rejected with 429 and a Retry-After header.
 //
 // The expected request to this endpoint is a JSON object with the form:
 //
 // { "username" : "VALUE", "password" : "PASSWORD" }
+//
+// or a form post with username and password fields. On success it responds with a UserResponse, otherwise with an
+// ErrorResponse.
 func (ac *AuthContext) LoginHandler(w http.ResponseWriter, r *http.Request) {
-	var formData map[string]interface{}
-	bodyData, err := io.ReadAll(r.Body)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	creds, apiErr := decodeCredentials(w, r)
+	if apiErr != nil {
+		writeError(w, apiErr)
 		return
 	}
-	err = json.Unmarshal(bodyData, &formData)
-	if err != nil {
-		panic(err)
-	}
 
-	username, password := formData["username"].(string), formData["password"].(string)
+	var limiterKeys []string
+	if ac.Limiter != nil {
+		limiterKeys = ac.loginLimiterKeys(r, creds.Username)
+		wait, err := ac.allowLogin(r.Context(), limiterKeys)
+		if err != nil {
+			// fail open so that a broken limiter store doesn't lock everyone out
+			log.Printf("Error checking login rate limit for user %s: %s", creds.Username, err)
+		} else if wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeErrorCode(w, http.StatusTooManyRequests, ErrCodeRateLimited, "Too many log-in attempts, try again later")
+			return
+		}
+	}
 
-	u, err := ac.Ac.LoadUserByUsername(username, r.Context())
-	if errors.Is(err, sessions.ErrUserNotFound) {
-		log.Printf("Error logging in user %s: %s", username, err)
-		w.WriteHeader(http.StatusUnauthorized)
+	u, err := ac.loadUserForLogin(r.Context(), creds.Username)
+	if err != nil && !errors.Is(err, sessions.ErrUserNotFound) {
+		// there are a number of error scenarios to handle here, bjust just declare a server error for now
+		log.Printf("Error logging in user %s: %s", creds.Username, err)
+		writeError(w, internalError)
 		return
 	}
+
+	// Unknown usernames are checked against a dummy hash so that they take as long as a wrong password and get
+	// exactly the same response, which keeps the endpoint from revealing which usernames exist.
+	userFound := err == nil
+	var ok, needsRehash bool
+	if userFound {
+		ok, needsRehash, err = ac.verifyPassword(creds.Password, u.HashedPassword)
+	} else {
+		// only the time this takes matters, not its result
+		ac.dummyHasher.Verify(creds.Password, ac.dummyHash)
+		err = nil
+	}
 	if err != nil {
-		// there are a number of error scenarios to handle here, bjust just declare a server error for now
-		log.Printf("Error logging in user %s: %s", username, err)
-		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Error verifying password for user %s: %s", creds.Username, err)
+	}
+	if !userFound || !ok || err != nil {
+		log.Printf("Failed log-in attempt for user %s", creds.Username)
+		for _, key := range limiterKeys {
+			if err := ac.Limiter.Failure(r.Context(), key); err != nil {
+				log.Printf("Error recording failed log-in for %s: %s", key, err)
+			}
+		}
+		writeErrorCode(w, http.StatusUnauthorized, ErrCodeInvalidCredentials, "Invalid username or password")
+		return
+	}
+	// only the username's failures are cleared; a client working through a list of accounts stays throttled
+	if len(limiterKeys) > 0 {
+		if err := ac.Limiter.Success(r.Context(), limiterKeys[0]); err != nil {
+			log.Printf("Error recording log-in for %s: %s", limiterKeys[0], err)
+		}
+	}
+	if needsRehash {
+		ac.upgradePasswordHash(r.Context(), u, creds.Password)
+	}
+
+	if !ac.startSession(w, r, u.UserId) {
 		return
 	}
+	writeJSON(w, http.StatusOK, UserResponse{UserId: u.UserId, Username: u.Username})
+}
+
+// Looks up a user by the username they logged in with. Accounts created before the policy normalized usernames
+// may be stored in their original form, so an exact match of that form comes first and the normalized form is
+// tried after it. A newer account with the normalized form can then never take over the logins of an older one.
+func (ac *AuthContext) loadUserForLogin(ctx context.Context, username string) (sessions.User, error) {
+	if ac.Policy == nil {
+		return ac.Users.LoadUserByUsername(ctx, username)
+	}
+	return ac.loadUsernameOwner(ctx, username, ac.Policy.NormalizeUsername(username))
+}
 
-	err = bcrypt.CompareHashAndPassword([]byte(u.HashedPassword), []byte(password))
+// Returns the user whose stored username is exactly the submitted one or else its normalized form, and
+// sessions.ErrUserNotFound if neither is taken. Registration checks both, so that a name can't be registered when
+// an account stored before usernames were normalized would match it at login.
+func (ac *AuthContext) loadUsernameOwner(ctx context.Context, submitted string, normalized string) (sessions.User, error) {
+	if submitted != normalized {
+		u, err := ac.Users.LoadUserByUsername(ctx, submitted)
+		if !errors.Is(err, sessions.ErrUserNotFound) {
+			return u, err
+		}
+	}
+	return ac.Users.LoadUserByUsername(ctx, normalized)
+}
 
+// Replaces a user's stored password hash with one from the preferred hasher. This happens after a successful login
+// and failures are only logged, since the old hash still works.
+func (ac *AuthContext) upgradePasswordHash(ctx context.Context, u sessions.User, password string) {
+	hashedPassword, err := ac.Hasher.Hash(password)
 	if err != nil {
-		fmt.Println(err)
-		// yodo consider if this should be BadRequest or something generic so as to not
-		// let an intruder know if the username already exists
-		w.WriteHeader(http.StatusBadRequest)
-		w.Write([]byte("Log-in failure"))
+		log.Printf("Error rehashing password for user %s: %s", u.UserId, err)
 		return
 	}
+	u.HashedPassword = hashedPassword
+	if err := ac.Users.UpdateUser(ctx, u); err != nil {
+		log.Printf("Error saving rehashed password for user %s: %s", u.UserId, err)
+	}
+}
 
-	sessionId, cookie := sessions.LoginHandler(ac.Secret, ac.Duration)
-
-	var nSession sessions.Session
-	nSession.Id = sessions.SessionId(sessionId)
-	nSession.ExpiresAt = cookie.Expires
-	nSession.UserId = u.UserId
-	err = ac.Ac.SaveSession(nSession)
+// Creates and stores a new session for the user and sets its cookie on the response. A guest session the request
+// was made with is replaced by the new session, which takes over its data. If the session cannot be saved an error
+// response is written and false is returned.
+func (ac *AuthContext) startSession(w http.ResponseWriter, r *http.Request, userId string) bool {
+	nSession, cookie := ac.newSession(r, userId)
+
+	var err error
+	guest, rs, isGuest := ac.requestGuestSession(r)
+	if isGuest {
+		// the guest's session gets a new id, so that one planted by an attacker before login is useless after it
+		nSession.Data = guest.Data
+		err = sessions.ReplaceSession(r.Context(), ac.Sessions, string(guest.Id), nSession)
+	} else {
+		err = ac.Sessions.SaveSession(r.Context(), nSession)
+	}
 	if err != nil {
 		// log it out
 		log.Printf("Error inserting session into DB: %s", err.Error())
-		// better error handling is needed here for when usernames
-		// don't follow certain rules or are not unique
-		// but for now, this works
-		w.WriteHeader(http.StatusInternalServerError)
-		return
+		writeError(w, internalError)
+		return false
+	}
+	if isGuest && rs != nil {
+		rs.markReplaced()
 	}
 	http.SetCookie(w, cookie)
-	w.Write([]byte("Logged in"))
+	ac.setCSRFCookie(w, ac.CSRFTokenForSession(string(nSession.Id)))
+	return true
 }
 
-// Logs out a user by deleting the session id from the database and setting a new expired cookie in the response. There is
-// no expected request body for this endpoint.
-func (ac *AuthContext) LogoutHandler(w http.ResponseWriter, r *http.Request) {
-	_, err := r.Cookie("session_id")
-	if err != nil {
-		if err == http.ErrNoCookie {
-			http.Error(w, "Not authenticated, no session cookie", http.StatusUnauthorized)
-			return
+// Returns a new session for the user, starting now, and its cookie. The user agent and IP address of the request
+// are recorded so the user can tell their sessions apart.
+func (ac *AuthContext) newSession(r *http.Request, userId string) (sessions.Session, *http.Cookie) {
+	sessionId, cookie := sessions.LoginHandler(ac.Keys, ac.Duration, ac.Cookie, ac.cookieOptions()...)
+
+	var nSession sessions.Session
+	nSession.Id = sessions.SessionId(sessionId)
+	nSession.CreatedAt = ac.Clock.Now()
+	nSession.ExpiresAt = nSession.CreatedAt.Add(ac.Duration)
+	nSession.LastSeenAt = nSession.CreatedAt
+	nSession.UserId = userId
+	nSession.UserAgent = r.UserAgent()
+	nSession.IPAddress = ac.clientIP(r)
+	return nSession, cookie
+}
+
+// returns the options that make the sessions package use the AuthContext's clock and session ids
+func (ac *AuthContext) cookieOptions() []sessions.Option {
+	return []sessions.Option{sessions.WithClock(ac.Clock), sessions.WithSessionIdGenerator(ac.SessionIds)}
+}
+
+// Returns the verified session id from the request's session cookie, or the error to send to the client if the
+// cookie is missing or its signature is invalid.
+func (ac *AuthContext) verifyRequest(r *http.Request) (string, *apiError) {
+	_, err := r.Cookie(ac.Cookie.CookieName())
+	if errors.Is(err, http.ErrNoCookie) {
+		return "", &apiError{
+			Status:  http.StatusUnauthorized,
+			Code:    ErrCodeNotAuthenticated,
+			Message: "Not authenticated, no session cookie",
 		}
 	}
 
-	sessionId, isValid := sessions.VerifyRequestSessionCookie(r, ac.Secret)
-
+	sessionId, isValid := sessions.VerifyRequestSessionCookie(r, ac.Keys, ac.Cookie)
 	if !isValid {
-		http.Error(w, "Invalid session cookie", http.StatusUnauthorized)
+		return "", &apiError{
+			Status:  http.StatusUnauthorized,
+			Code:    ErrCodeInvalidSession,
+			Message: "Invalid session cookie",
+		}
 	}
+	return sessionId, nil
+}
 
-	err = ac.Ac.DeleteSessionById(sessionId)
-	if err != nil {
-		log.Printf("Error deleting session")
-		w.WriteHeader(http.StatusInternalServerError)
+// Logs out a user by deleting the session id from the database and setting a new expired cookie in the response. There is
+// no expected request body for this endpoint.
+func (ac *AuthContext) LogoutHandler(w http.ResponseWriter, r *http.Request) {
+	sessionId, apiErr := ac.verifyRequest(r)
+	if apiErr != nil {
+		writeError(w, apiErr)
 		return
 	}
 
-	http.SetCookie(w, sessions.LogoutHandler())
-	w.Write([]byte("Logged out"))
+	err := ac.Sessions.DeleteSessionById(r.Context(), sessionId)
+	if err != nil && !errors.Is(err, sessions.ErrSessionNotFound) {
+		log.Printf("Error deleting session: %s", err)
+		writeError(w, internalError)
+		return
+	}
+
+	http.SetCookie(w, sessions.LogoutHandler(ac.Cookie, ac.cookieOptions()...))
+	ac.setCSRFCookie(w, "")
+	writeJSON(w, http.StatusOK, MessageResponse{Message: "Logged out"})
 }
 
-// A basic middleware that checks if a user has a valid unexpired session.
+// A basic middleware that checks if a user has a valid unexpired session. Handlers behind it can look up the
+// authenticated user with UserIdFromContext or UserFromContext. Guest sessions issued by GuestMiddleware are
+// turned away as not authenticated.
 func (ac *AuthContext) Authmiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, err := r.Cookie("session_id")
-		if err != nil {
-			if err == http.ErrNoCookie {
-				http.Error(w, "Not authenticated, no session cookie", http.StatusUnauthorized)
+		// behind GuestMiddleware the session has been loaded already
+		if rs := requestSessionFromContext(r.Context()); rs != nil {
+			if _, ok := rs.userId(); !ok {
+				writeErrorCode(w, http.StatusUnauthorized, ErrCodeNotAuthenticated, "Not authenticated, not logged in")
 				return
 			}
+			next.ServeHTTP(w, r)
+			return
 		}
 
-		sessionId, isValid := sessions.VerifyRequestSessionCookie(r, ac.Secret)
-		//fmt.Println(sessionId)
-		if !isValid {
-			http.Error(w, "Invalid session cookie", http.StatusUnauthorized)
-		}
-
-		// here we would look up the cookie in the db to get the user info
-
-		nSession, err := ac.Ac.LoadSessionById(sessionId, r.Context())
-		if err != nil {
-			log.Printf("Error loading session: %s", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
+		nSession, apiErr := ac.loadRequestSession(w, r)
+		if apiErr != nil {
+			if apiErr.Code == ErrCodeInvalidSession || apiErr.Code == ErrCodeSessionExpired {
+				http.SetCookie(w, sessions.LogoutHandler(ac.Cookie, ac.cookieOptions()...)) // Clear client-side cookie
+			}
+			writeError(w, apiErr)
 			return
-			// error retrieving session
 		}
-
-		// Check if session has expired
-		if time.Now().After(nSession.ExpiresAt) {
-			//if nSession.ExpiresAt.After(time.Now()) {
-			log.Printf("Unauthorized: Session ID %s expired.", sessionId)
-			http.Error(w, "Unauthorized: Session expired", http.StatusUnauthorized)
-			// Delete expired session from DB asynchronously or in a cleanup routine
-			go func() {
-				delErr := ac.Ac.DeleteSessionById(sessionId)
-				if delErr != nil {
-					log.Printf("Error deleting expired session %s: %v", sessionId, delErr)
-				}
-			}()
-			http.SetCookie(w, sessions.LogoutHandler()) // Clear client-side cookie
+		if nSession.UserId == "" {
+			writeErrorCode(w, http.StatusUnauthorized, ErrCodeNotAuthenticated, "Not authenticated, not logged in")
 			return
 		}
 
-		userId := nSession.UserId
-		//fmt.Println(nSession)
-
-		ctx := r.Context()
-		ctx = context.WithValue(ctx, "userId", userId)
-		ctx = context.WithValue(ctx, "session_id", sessionId)
+		// make the session and the user it belongs to available to the handlers behind this middleware through
+		// SessionFromContext, UserIdFromContext and UserFromContext
+		ctx, rs := ac.withSession(r.Context(), nSession)
 
 		next.ServeHTTP(w, r.WithContext(ctx))
 
+		ac.saveSessionData(r.Context(), rs)
 	})
 }
 
-func hash(password string) (string, error) {
-	bts, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+// Returns the valid, unexpired session the request's session cookie refers to, renewing it and re-issuing its
+// cookie if it is due. Otherwise it returns the error to send to the client, having deleted the session if it
+// expired.
+func (ac *AuthContext) loadRequestSession(w http.ResponseWriter, r *http.Request) (sessions.Session, *apiError) {
+	sessionId, apiErr := ac.verifyRequest(r)
+	if apiErr != nil {
+		return sessions.Session{}, apiErr
+	}
+
+	nSession, err := ac.Sessions.LoadSessionById(r.Context(), sessionId)
+	if errors.Is(err, sessions.ErrSessionNotFound) {
+		return nSession, &apiError{
+			Status:  http.StatusUnauthorized,
+			Code:    ErrCodeInvalidSession,
+			Message: "Session not found",
+		}
+	}
 	if err != nil {
-		return "", err
+		log.Printf("Error loading session: %s", err.Error())
+		return nSession, internalError
+	}
+
+	// Check if session has expired
+	now := ac.Clock.Now()
+	if ac.sessionExpired(nSession, now) {
+		log.Printf("Unauthorized: Session %s expired.", sessions.SessionId(sessionId).Digest())
+		// the Reaper deletes expired sessions in bulk, but this one is already at hand
+		delErr := ac.Sessions.DeleteSessionById(r.Context(), sessionId)
+		if delErr != nil && !errors.Is(delErr, sessions.ErrSessionNotFound) {
+			log.Printf("Error deleting expired session %s: %v", sessions.SessionId(sessionId).Digest(), delErr)
+		}
+		return nSession, &apiError{
+			Status:  http.StatusUnauthorized,
+			Code:    ErrCodeSessionExpired,
+			Message: "Unauthorized: Session expired",
+		}
 	}
-	return string(bts), nil
-}
 
-func passwordIsEquivilent(password string, hashedPassword string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
-	return err == nil
+	renew := ac.shouldRenew(nSession, now)
+	if renew || now.Sub(nSession.LastSeenAt) >= lastSeenInterval {
+		if renew {
+			nSession.ExpiresAt = ac.renewedExpiry(nSession, now)
+		}
+		nSession.LastSeenAt = now
+		err = ac.Sessions.UpdateSession(r.Context(), nSession)
+		if err != nil {
+			// the session is still valid, so carry on with the old expiry rather than failing the request
+			log.Printf("Error renewing session %s: %s", sessions.SessionId(sessionId).Digest(), err)
+		} else if renew {
+			http.SetCookie(w, sessions.RenewCookie(sessionId, ac.Keys, nSession.ExpiresAt, ac.Cookie))
+		}
+	}
+	return nSession, nil
 }
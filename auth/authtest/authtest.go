@@ -0,0 +1,202 @@
+// Package authtest helps test code built on auth.AuthContext. It sets up an AuthContext over an in-memory store with
+// a fake clock, predictable ids and a fast password hasher, and has helpers to register and log in users, mint
+// session cookies for any user and move time forward to expire or renew sessions.
+//
+//	env := authtest.New(t)
+//	cookies := env.Login("alice", "correct horse battery")
+//	w := env.Serve(env.Auth.Authmiddleware(myHandler), authtest.NewRequest(http.MethodGet, "/me", "", cookies...))
+package authtest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cameronmore/go-sessions/auth"
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// A sessions.Clock that only moves when told to. It is safe for concurrent use.
+type Clock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// Returns a clock stopped at the given time.
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+func (c *Clock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Moves the clock forward by d.
+func (c *Clock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Sets the clock to the given time.
+func (c *Clock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// Returns an id generator that counts up from 1, making the ids prefix-1, prefix-2 and so on. It is safe for
+// concurrent use.
+func SequentialIds(prefix string) sessions.IdGenerator {
+	var n atomic.Int64
+	return func() string {
+		return fmt.Sprintf("%s-%d", prefix, n.Add(1))
+	}
+}
+
+// Returns an argon2id hasher with the smallest sensible parameters, which keeps registering and logging in users
+// fast in tests. Never use it outside of tests.
+func FastHasher() *auth.Argon2idHasher {
+	return &auth.Argon2idHasher{
+		Memory:      64,
+		Iterations:  1,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+// The time a new Env's clock starts at.
+var Epoch = time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+// The session duration of the AuthContext of a new Env.
+const SessionDuration = time.Hour
+
+// An AuthContext set up for a test along with the store and clock behind it.
+type Env struct {
+	T     testing.TB
+	Auth  *auth.AuthContext
+	Store *auth.MemoryAuthStore
+	Clock *Clock
+}
+
+// Returns an Env whose AuthContext keeps users and sessions in a new MemoryAuthStore, signs cookies with a test
+// secret, tells the time from a Clock starting at Epoch and hashes passwords with FastHasher. Sessions last
+// SessionDuration, and new sessions and users get the ids session-1, session-2, ... and user-1, user-2, ... The
+// options are applied after those settings, so they can override any of them.
+func New(t testing.TB, opts ...auth.Option) *Env {
+	t.Helper()
+	clock := NewClock(Epoch)
+	env := &Env{
+		T:     t,
+		Store: auth.NewMemoryAuthStore(auth.WithEvictionClock(clock)),
+		Clock: clock,
+	}
+	opts = append([]auth.Option{
+		auth.WithClock(clock),
+		auth.WithSessionIdGenerator(SequentialIds("session")),
+		auth.WithUserIdGenerator(SequentialIds("user")),
+		auth.WithPasswordHasher(FastHasher()),
+	}, opts...)
+	env.Auth = auth.NewAuthContext(env.Store, sessions.NewKeyringFromSecret("authtest signing secret"), SessionDuration, opts...)
+	t.Cleanup(env.Auth.Close)
+	return env
+}
+
+// Returns a request with the given body, sent as JSON unless it is empty, and cookies.
+func NewRequest(method string, target string, body string, cookies ...*http.Cookie) *http.Request {
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	for _, c := range cookies {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+// Serves the request with the handler and returns the recorded response.
+func (e *Env) Serve(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+// Registers a user through RegisterHandler and returns them along with the cookies of the session it started. The
+// test fails if registration does.
+func (e *Env) Register(username string, password string) (sessions.User, []*http.Cookie) {
+	e.T.Helper()
+	w := e.Serve(http.HandlerFunc(e.Auth.RegisterHandler), NewRequest(http.MethodPost, "/register", credentials(username, password)))
+	if w.Code != http.StatusCreated {
+		e.T.Fatalf("registering %s returned %d: %s", username, w.Code, w.Body)
+	}
+	var resp auth.UserResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		e.T.Fatalf("decoding the registration response: %s", err)
+	}
+	u, err := e.Store.LoadUserByUserId(e.T.Context(), resp.UserId)
+	if err != nil {
+		e.T.Fatalf("loading the registered user: %s", err)
+	}
+	return u, w.Result().Cookies()
+}
+
+// Logs a user in through LoginHandler and returns the session and CSRF cookies it set. The test fails if the login
+// does.
+func (e *Env) Login(username string, password string) []*http.Cookie {
+	e.T.Helper()
+	w := e.Serve(http.HandlerFunc(e.Auth.LoginHandler), NewRequest(http.MethodPost, "/login", credentials(username, password)))
+	if w.Code != http.StatusOK {
+		e.T.Fatalf("logging in %s returned %d: %s", username, w.Code, w.Body)
+	}
+	return w.Result().Cookies()
+}
+
+// Creates a session for the user id directly in the AuthContext's session store, whether or not such a user
+// exists, and returns its signed session cookie. The session starts now by the AuthContext's clock, lasts its
+// Duration and gets an id from its SessionIds.
+func (e *Env) CookieFor(userId string) *http.Cookie {
+	e.T.Helper()
+	cookie, sessionId := sessions.NewCookieWithSessionId(e.Auth.Keys, e.Auth.Duration, e.Auth.Cookie,
+		sessions.WithClock(e.Auth.Clock), sessions.WithSessionIdGenerator(e.Auth.SessionIds))
+	now := e.Auth.Clock.Now()
+	err := e.Auth.Sessions.SaveSession(e.T.Context(), sessions.Session{
+		Id:         sessions.SessionId(sessionId),
+		UserId:     userId,
+		CreatedAt:  now,
+		ExpiresAt:  now.Add(e.Auth.Duration),
+		LastSeenAt: now,
+	})
+	if err != nil {
+		e.T.Fatalf("saving a session for %s: %s", userId, err)
+	}
+	return cookie
+}
+
+// Moves the Env's clock forward by d.
+func (e *Env) Advance(d time.Duration) {
+	e.Clock.Advance(d)
+}
+
+// Returns the cookie with the given name from a list, or nil if there is none.
+func FindCookie(cookies []*http.Cookie, name string) *http.Cookie {
+	for _, c := range cookies {
+		if c.Name == name {
+			return c
+		}
+	}
+	return nil
+}
+
+func credentials(username string, password string) string {
+	body, _ := json.Marshal(map[string]string{"username": username, "password": password})
+	return string(body)
+}
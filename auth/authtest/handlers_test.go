@@ -0,0 +1,420 @@
+package authtest_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cameronmore/go-sessions/auth"
+	"github.com/cameronmore/go-sessions/auth/authtest"
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+const password = "correct horse battery"
+
+// the routes of the example server in main.go
+func newMux(env *authtest.Env) *http.ServeMux {
+	ac := env.Auth
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /register", ac.RegisterHandler)
+	mux.HandleFunc("POST /login", ac.LoginHandler)
+	mux.Handle("POST /logout", ac.CSRFMiddleware(http.HandlerFunc(ac.LogoutHandler)))
+	mux.Handle("/me", ac.Authmiddleware(ac.CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, err := auth.UserFromContext(r.Context())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		json.NewEncoder(w).Encode(auth.UserResponse{UserId: u.UserId, Username: u.Username})
+	}))))
+	return mux
+}
+
+// checks the status and, for error responses, the code of a response
+func expect(t *testing.T, name string, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if w.Code != status {
+		t.Errorf("%s returned %d, want %d: %s", name, w.Code, status, w.Body)
+		return
+	}
+	if code == "" {
+		return
+	}
+	var resp auth.ErrorResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp.Error.Code != code {
+		t.Errorf("%s returned error code %q, want %q: %s", name, resp.Error.Code, code, w.Body)
+	}
+}
+
+func sessionCookie(env *authtest.Env, cookies []*http.Cookie) *http.Cookie {
+	return authtest.FindCookie(cookies, env.Auth.Cookie.CookieName())
+}
+
+func TestRegisterHandler(t *testing.T) {
+	env := authtest.New(t)
+	mux := newMux(env)
+
+	u, cookies := env.Register("Alice", password)
+	if u.Username != "alice" {
+		t.Errorf("registered username = %q, want it normalized to alice", u.Username)
+	}
+	if sessionCookie(env, cookies) == nil || authtest.FindCookie(cookies, "csrf_token") == nil {
+		t.Errorf("registration set cookies %v, want a session and a CSRF cookie", cookies)
+	}
+
+	expect(t, "registering a taken username", env.Serve(mux, authtest.NewRequest(http.MethodPost, "/register", `{"username":"alice","password":"`+password+`"}`)),
+		http.StatusConflict, auth.ErrCodeUsernameTaken)
+	expect(t, "registering with a weak password", env.Serve(mux, authtest.NewRequest(http.MethodPost, "/register", `{"username":"bob","password":"short"}`)),
+		http.StatusUnprocessableEntity, auth.ErrCodeValidationFailed)
+	expect(t, "registering without a password", env.Serve(mux, authtest.NewRequest(http.MethodPost, "/register", `{"username":"bob"}`)),
+		http.StatusBadRequest, auth.ErrCodeInvalidRequest)
+	expect(t, "registering with malformed JSON", env.Serve(mux, authtest.NewRequest(http.MethodPost, "/register", `{"username":`)),
+		http.StatusBadRequest, auth.ErrCodeInvalidRequest)
+
+	r := authtest.NewRequest(http.MethodPost, "/register", "username=bob")
+	r.Header.Set("Content-Type", "text/plain")
+	expect(t, "registering with a text body", env.Serve(mux, r), http.StatusUnsupportedMediaType, auth.ErrCodeUnsupportedMediaType)
+}
+
+func TestLoginHandler(t *testing.T) {
+	env := authtest.New(t)
+	mux := newMux(env)
+	env.Register("alice", password)
+
+	cookies := env.Login("alice", password)
+	expect(t, "a request with the login's cookie", env.Serve(mux, authtest.NewRequest(http.MethodGet, "/me", "", cookies...)), http.StatusOK, "")
+
+	expect(t, "logging in with the wrong password", env.Serve(mux, authtest.NewRequest(http.MethodPost, "/login", `{"username":"alice","password":"wrong password"}`)),
+		http.StatusUnauthorized, auth.ErrCodeInvalidCredentials)
+	expect(t, "logging in as an unknown user", env.Serve(mux, authtest.NewRequest(http.MethodPost, "/login", `{"username":"mallory","password":"`+password+`"}`)),
+		http.StatusUnauthorized, auth.ErrCodeInvalidCredentials)
+	expect(t, "logging in without credentials", env.Serve(mux, authtest.NewRequest(http.MethodPost, "/login", `{}`)),
+		http.StatusBadRequest, auth.ErrCodeInvalidRequest)
+
+	// usernames are normalized at login as well
+	env.Login("ALICE", password)
+}
+
+func TestLogoutHandler(t *testing.T) {
+	env := authtest.New(t)
+	mux := newMux(env)
+	env.Register("alice", password)
+	cookies := env.Login("alice", password)
+	csrf := authtest.FindCookie(cookies, "csrf_token")
+
+	expect(t, "logging out without a CSRF token", env.Serve(mux, authtest.NewRequest(http.MethodPost, "/logout", "", cookies...)),
+		http.StatusForbidden, auth.ErrCodeInvalidCSRFToken)
+
+	r := authtest.NewRequest(http.MethodPost, "/logout", "", cookies...)
+	r.Header.Set("X-CSRF-Token", csrf.Value)
+	w := env.Serve(mux, r)
+	expect(t, "logging out", w, http.StatusOK, "")
+	if c := sessionCookie(env, w.Result().Cookies()); c == nil || c.MaxAge >= 0 {
+		t.Errorf("logging out set session cookie %v, want it cleared", c)
+	}
+	expect(t, "a request after logging out", env.Serve(mux, authtest.NewRequest(http.MethodGet, "/me", "", cookies...)),
+		http.StatusUnauthorized, auth.ErrCodeInvalidSession)
+
+	expect(t, "logging out without a session", env.Serve(mux, authtest.NewRequest(http.MethodPost, "/logout", "")),
+		http.StatusUnauthorized, auth.ErrCodeNotAuthenticated)
+}
+
+func TestAuthmiddleware(t *testing.T) {
+	env := authtest.New(t)
+	mux := newMux(env)
+	u, _ := env.Register("alice", password)
+
+	w := env.Serve(mux, authtest.NewRequest(http.MethodGet, "/me", "", env.CookieFor(u.UserId)))
+	expect(t, "a request with a minted cookie", w, http.StatusOK, "")
+	var resp auth.UserResponse
+	if json.Unmarshal(w.Body.Bytes(), &resp); resp.UserId != u.UserId {
+		t.Errorf("the handler saw user %q, want %q", resp.UserId, u.UserId)
+	}
+
+	expect(t, "a request without a cookie", env.Serve(mux, authtest.NewRequest(http.MethodGet, "/me", "")),
+		http.StatusUnauthorized, auth.ErrCodeNotAuthenticated)
+
+	forged := env.CookieFor(u.UserId)
+	forged.Value += "x"
+	expect(t, "a request with a forged cookie", env.Serve(mux, authtest.NewRequest(http.MethodGet, "/me", "", forged)),
+		http.StatusUnauthorized, auth.ErrCodeInvalidSession)
+
+	// a correctly signed cookie for a session the store doesn't know
+	unknown, _ := sessions.NewCookieWithSessionId(env.Auth.Keys, time.Hour, env.Auth.Cookie)
+	expect(t, "a request with an unknown session", env.Serve(mux, authtest.NewRequest(http.MethodGet, "/me", "", unknown)),
+		http.StatusUnauthorized, auth.ErrCodeInvalidSession)
+}
+
+func TestCookieForSeparateSessionStore(t *testing.T) {
+	sessionStore := auth.NewMemoryAuthStore()
+	env := authtest.New(t, auth.WithSessionStore(sessionStore))
+	cookie := env.CookieFor("user-1")
+	sessionId, _ := sessions.VerifySessionId(cookie.Value, env.Auth.Keys)
+	if _, err := sessionStore.LoadSessionById(t.Context(), sessionId); err != nil {
+		t.Fatalf("the minted session is not in the AuthContext's session store: %v", err)
+	}
+	h := env.Auth.Authmiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	expect(t, "a request with the minted cookie", env.Serve(h, authtest.NewRequest(http.MethodGet, "/", "", cookie)), http.StatusOK, "")
+}
+
+func TestAuthmiddlewareExpiry(t *testing.T) {
+	env := authtest.New(t)
+	mux := newMux(env)
+	u, _ := env.Register("alice", password)
+	cookie := env.CookieFor(u.UserId)
+	sessionId, _ := sessions.VerifySessionId(cookie.Value, env.Auth.Keys)
+
+	// past half of the session's duration the middleware renews it
+	env.Advance(authtest.SessionDuration/2 + time.Minute)
+	w := env.Serve(mux, authtest.NewRequest(http.MethodGet, "/me", "", cookie))
+	expect(t, "a request past the renewal point", w, http.StatusOK, "")
+	if sessionCookie(env, w.Result().Cookies()) == nil {
+		t.Error("the renewed session's cookie was not re-issued")
+	}
+	s, err := env.Store.LoadSessionById(t.Context(), sessionId)
+	if want := env.Clock.Now().Add(authtest.SessionDuration); err != nil || !s.ExpiresAt.Equal(want) {
+		t.Errorf("renewed session expires at %v, %v, want %v", s.ExpiresAt, err, want)
+	}
+
+	// left alone for longer than its duration, it expires and is deleted
+	env.Advance(authtest.SessionDuration + time.Second)
+	expect(t, "a request with an expired session", env.Serve(mux, authtest.NewRequest(http.MethodGet, "/me", "", cookie)),
+		http.StatusUnauthorized, auth.ErrCodeSessionExpired)
+	if _, err := env.Store.LoadSessionById(t.Context(), sessionId); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("loading the expired session returned %v, want %v", err, sessions.ErrSessionNotFound)
+	}
+}
+
+func TestMaxLifetime(t *testing.T) {
+	const maxLifetime = 3 * time.Hour
+	env := authtest.New(t, auth.WithMaxLifetime(maxLifetime))
+	mux := newMux(env)
+	u, _ := env.Register("alice", password)
+	cookie := env.CookieFor(u.UserId)
+	sessionId, _ := sessions.VerifySessionId(cookie.Value, env.Auth.Keys)
+	deadline := env.Clock.Now().Add(maxLifetime)
+
+	// a session in constant use keeps being renewed, but never past its absolute lifetime
+	for env.Clock.Now().Add(40 * time.Minute).Before(deadline) {
+		env.Advance(40 * time.Minute)
+		expect(t, "a request within the lifetime", env.Serve(mux, authtest.NewRequest(http.MethodGet, "/me", "", cookie)), http.StatusOK, "")
+		s, err := env.Store.LoadSessionById(t.Context(), sessionId)
+		if err != nil || s.ExpiresAt.After(deadline) {
+			t.Fatalf("the renewed session expires at %v, %v, after the deadline %v", s.ExpiresAt, err, deadline)
+		}
+	}
+	if s, _ := env.Store.LoadSessionById(t.Context(), sessionId); !s.ExpiresAt.Equal(deadline) {
+		t.Errorf("the last renewal set the expiry to %v, want the deadline %v", s.ExpiresAt, deadline)
+	}
+
+	env.Clock.Set(deadline.Add(time.Second))
+	expect(t, "a request past the lifetime", env.Serve(mux, authtest.NewRequest(http.MethodGet, "/me", "", cookie)),
+		http.StatusUnauthorized, auth.ErrCodeSessionExpired)
+
+	// a session whose idle timeout outlasts a shortened lifetime still ends at CreatedAt+MaxLifetime
+	cookie = env.CookieFor(u.UserId)
+	env.Auth.MaxLifetime = 10 * time.Minute
+	env.Advance(10*time.Minute + time.Second)
+	expect(t, "a request past a shortened lifetime", env.Serve(mux, authtest.NewRequest(http.MethodGet, "/me", "", cookie)),
+		http.StatusUnauthorized, auth.ErrCodeSessionExpired)
+}
+
+func TestDeterministicIds(t *testing.T) {
+	env := authtest.New(t)
+	u, cookies := env.Register("alice", password)
+	if u.UserId != "user-1" {
+		t.Errorf("registered user id = %q, want user-1", u.UserId)
+	}
+	cookie := sessionCookie(env, cookies)
+	if sessionId, err := sessions.VerifySessionId(cookie.Value, env.Auth.Keys); err != nil || sessionId != "session-1" {
+		t.Errorf("session id = %q, %v, want session-1", sessionId, err)
+	}
+	if want := authtest.Epoch.Add(authtest.SessionDuration); !cookie.Expires.Equal(want) {
+		t.Errorf("session cookie expires at %v, want %v", cookie.Expires, want)
+	}
+}
+
+// counts the session updates that reach the store
+type countingSessionStore struct {
+	sessions.SessionStore
+	updates atomic.Int64
+}
+
+func (s *countingSessionStore) UpdateSession(ctx context.Context, session sessions.Session) error {
+	s.updates.Add(1)
+	return s.SessionStore.UpdateSession(ctx, session)
+}
+
+func TestSessionData(t *testing.T) {
+	type cart struct {
+		Items []string `json:"items"`
+	}
+	cartKey := auth.SessionKey[cart]("cart")
+
+	store := &countingSessionStore{SessionStore: auth.NewMemoryAuthStore()}
+	env := authtest.New(t, auth.WithSessionStore(store))
+	env.Register("alice", password)
+	cookies := env.Login("alice", password)
+	csrf := authtest.FindCookie(cookies, "csrf_token")
+
+	h := env.Auth.Authmiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, _ := cartKey.Get(r.Context())
+		switch r.Method {
+		case http.MethodPost:
+			c.Items = append(c.Items, r.URL.Query().Get("item"))
+			if err := cartKey.Set(r.Context(), c); err != nil {
+				t.Errorf("setting the cart: %s", err)
+			}
+		case http.MethodPut:
+			// storing the same value again is not a change
+			cartKey.Set(r.Context(), c)
+		case http.MethodDelete:
+			cartKey.Delete(r.Context())
+		}
+		json.NewEncoder(w).Encode(c)
+	}))
+	request := func(method string, target string) cart {
+		t.Helper()
+		r := authtest.NewRequest(method, target, "", cookies...)
+		r.Header.Set("X-CSRF-Token", csrf.Value)
+		w := env.Serve(h, r)
+		var c cart
+		if err := json.Unmarshal(w.Body.Bytes(), &c); err != nil {
+			t.Fatalf("%s %s returned %d: %s", method, target, w.Code, w.Body)
+		}
+		return c
+	}
+
+	request(http.MethodPost, "/cart?item=apple")
+	request(http.MethodPost, "/cart?item=pear")
+	if c := request(http.MethodGet, "/cart"); fmt.Sprint(c.Items) != "[apple pear]" {
+		t.Errorf("the cart holds %v, want [apple pear]", c.Items)
+	}
+	if n := store.updates.Load(); n != 2 {
+		t.Errorf("the store saw %d session updates, want one for each change", n)
+	}
+	request(http.MethodPut, "/cart")
+	if n := store.updates.Load(); n != 2 {
+		t.Errorf("storing an unchanged value updated the session, %d updates", n)
+	}
+
+	request(http.MethodDelete, "/cart")
+	if c := request(http.MethodGet, "/cart"); len(c.Items) != 0 {
+		t.Errorf("after deleting the cart it holds %v", c.Items)
+	}
+
+	// outside of Authmiddleware there is no session to keep data in
+	if err := cartKey.Set(t.Context(), cart{}); !errors.Is(err, auth.ErrNoSessionInContext) {
+		t.Errorf("Set() without a session returned %v, want %v", err, auth.ErrNoSessionInContext)
+	}
+}
+
+func TestOverlappingSessionDataSave(t *testing.T) {
+	cartKey := auth.SessionKey[[]string]("cart")
+
+	env := authtest.New(t)
+	mux := newMux(env)
+	u, _ := env.Register("alice", password)
+	cookie := env.CookieFor(u.UserId)
+	sessionId, _ := sessions.VerifySessionId(cookie.Value, env.Auth.Keys)
+
+	// a slow request changes the cart, and while it is still running another one renews the session
+	changed, finish, done := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	slow := env.Auth.Authmiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cartKey.Set(r.Context(), []string{"apple"})
+		close(changed)
+		<-finish
+	}))
+	go func() {
+		defer close(done)
+		env.Serve(slow, authtest.NewRequest(http.MethodGet, "/cart", "", cookie))
+	}()
+	<-changed
+	env.Advance(authtest.SessionDuration/2 + time.Minute)
+	expect(t, "a request past the renewal point", env.Serve(mux, authtest.NewRequest(http.MethodGet, "/me", "", cookie)), http.StatusOK, "")
+	renewed, _ := env.Store.LoadSessionById(t.Context(), sessionId)
+	close(finish)
+	<-done
+
+	s, err := env.Store.LoadSessionById(t.Context(), sessionId)
+	if err != nil || !s.ExpiresAt.Equal(renewed.ExpiresAt) || !s.LastSeenAt.Equal(renewed.LastSeenAt) {
+		t.Errorf("after the slow request the session expires at %v, last seen %v, %v, want the renewal's %v, %v",
+			s.ExpiresAt, s.LastSeenAt, err, renewed.ExpiresAt, renewed.LastSeenAt)
+	}
+	if cart := string(s.Data["cart"]); cart != `["apple"]` {
+		t.Errorf("the slow request saved the cart as %s, want [\"apple\"]", cart)
+	}
+}
+
+func TestGuestSessions(t *testing.T) {
+	cartKey := auth.SessionKey[[]string]("cart")
+
+	env := authtest.New(t)
+	env.Register("alice", password)
+	ac := env.Auth
+	mux := http.NewServeMux()
+	mux.Handle("/cart", ac.GuestMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		items, _ := cartKey.Get(r.Context())
+		if item := r.URL.Query().Get("item"); item != "" {
+			items = append(items, item)
+			cartKey.Set(r.Context(), items)
+		}
+		json.NewEncoder(w).Encode(items)
+	})))
+	mux.Handle("/me", ac.GuestMiddleware(ac.Authmiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))))
+	mux.Handle("POST /login", ac.GuestMiddleware(http.HandlerFunc(ac.LoginHandler)))
+	cart := func(target string, cookies ...*http.Cookie) (string, *httptest.ResponseRecorder) {
+		t.Helper()
+		w := env.Serve(mux, authtest.NewRequest(http.MethodGet, target, "", cookies...))
+		var items []string
+		if err := json.Unmarshal(w.Body.Bytes(), &items); err != nil {
+			t.Fatalf("GET %s returned %d: %s", target, w.Code, w.Body)
+		}
+		return fmt.Sprint(items), w
+	}
+
+	_, w := cart("/cart")
+	guest := sessionCookie(env, w.Result().Cookies())
+	if guest == nil || authtest.FindCookie(w.Result().Cookies(), "csrf_token") == nil {
+		t.Fatalf("a new visitor got the cookies %v, want a session and a CSRF cookie", w.Result().Cookies())
+	}
+	guestSession, err := env.Store.LoadSessionById(t.Context(), "session-2")
+	if err != nil || guestSession.UserId != "" {
+		t.Fatalf("loading the guest session returned %+v, %v", guestSession, err)
+	}
+	if items, w := cart("/cart?item=apple", guest); items != "[apple]" || len(w.Result().Cookies()) != 0 {
+		t.Errorf("the guest's cart holds %s with the cookies %v, want [apple] and no new cookies", items, w.Result().Cookies())
+	}
+	expect(t, "/me as a guest", env.Serve(mux, authtest.NewRequest(http.MethodGet, "/me", "", guest)), http.StatusUnauthorized, auth.ErrCodeNotAuthenticated)
+
+	w = env.Serve(mux, authtest.NewRequest(http.MethodPost, "/login", `{"username":"alice","password":"`+password+`"}`, guest))
+	expect(t, "login as a guest", w, http.StatusOK, "")
+	promoted := sessionCookie(env, w.Result().Cookies())
+	if promoted == nil || promoted.Value == guest.Value {
+		t.Fatalf("logging in kept the guest's session cookie")
+	}
+	if _, err := env.Store.LoadSessionById(t.Context(), "session-2"); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("loading the guest session after login returned %v, want %v", err, sessions.ErrSessionNotFound)
+	}
+	// the old id is worth nothing after login, so its holder is just another guest
+	expect(t, "/me with the old guest cookie", env.Serve(mux, authtest.NewRequest(http.MethodGet, "/me", "", guest)), http.StatusUnauthorized, auth.ErrCodeNotAuthenticated)
+	if items, _ := cart("/cart", promoted); items != "[apple]" {
+		t.Errorf("after login the cart holds %s, want [apple]", items)
+	}
+	expect(t, "/me after login", env.Serve(mux, authtest.NewRequest(http.MethodGet, "/me", "", promoted)), http.StatusOK, "")
+
+	// registering promotes a guest session just as well, even without GuestMiddleware in front of the handler
+	_, w = cart("/cart?item=pear")
+	guest = sessionCookie(env, w.Result().Cookies())
+	w = env.Serve(http.HandlerFunc(ac.RegisterHandler), authtest.NewRequest(http.MethodPost, "/register", `{"username":"bob","password":"`+password+`"}`, guest))
+	expect(t, "register as a guest", w, http.StatusCreated, "")
+	if items, _ := cart("/cart", sessionCookie(env, w.Result().Cookies())); items != "[pear]" {
+		t.Errorf("after registering the cart holds %s, want [pear]", items)
+	}
+}
@@ -0,0 +1,119 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// An unexported type for the keys this package stores in a request context, so they cannot collide with keys
+// defined by other packages.
+type contextKey int
+
+const (
+	sessionContextKey contextKey = iota
+	userContextKey
+	csrfTokenContextKey
+)
+
+var ErrNoSessionInContext = errors.New("There is no authenticated session in the request context")
+
+// lazily loads the user for a request the first time it is asked for
+type userLoader struct {
+	once  sync.Once
+	store sessions.UserStore
+	user  sessions.User
+	err   error
+}
+
+func (l *userLoader) load(ctx context.Context, userId string) (sessions.User, error) {
+	l.once.Do(func() {
+		l.user, l.err = l.store.LoadUserByUserId(ctx, userId)
+	})
+	return l.user, l.err
+}
+
+// the authenticated or guest session of a request. Handlers change its data through SessionKeys, which marks it
+// dirty so that the middleware writes it back to the store.
+type requestSession struct {
+	mu      sync.Mutex
+	session sessions.Session
+	dirty   bool
+	// set once the session has been replaced by a logged-in one, after which its data is no longer saved
+	replaced bool
+}
+
+// Returns a copy of the context carrying the session and a loader for its user, along with the
+// session as carried by the context.
+func (ac *AuthContext) withSession(ctx context.Context, s sessions.Session) (context.Context, *requestSession) {
+	rs := &requestSession{session: s}
+	ctx = context.WithValue(ctx, sessionContextKey, rs)
+	ctx = context.WithValue(ctx, userContextKey, &userLoader{store: ac.Users})
+	return ctx, rs
+}
+
+// returns the session carried by the context, or nil if there is none
+func requestSessionFromContext(ctx context.Context) *requestSession {
+	rs, _ := ctx.Value(sessionContextKey).(*requestSession)
+	return rs
+}
+
+// Returns the id of the session's user, and false if there is no session or it is a guest session. The user id
+// never changes during a request, so it is read without the lock.
+func (rs *requestSession) userId() (string, bool) {
+	if rs == nil || rs.session.UserId == "" {
+		return "", false
+	}
+	return rs.session.UserId, true
+}
+
+// stops the session's data from being saved, since the session is gone
+func (rs *requestSession) markReplaced() {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.replaced = true
+	rs.dirty = false
+}
+
+// returns a copy of the session, including any changes to its data, that the caller is free to modify
+func (rs *requestSession) snapshot() sessions.Session {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	s := rs.session
+	s.Data = s.Data.Clone()
+	return s
+}
+
+// Returns the session that Authmiddleware authenticated for this request, or the guest session GuestMiddleware
+// issued, whose UserId is empty, and false if there is none. Its Data includes the changes made through SessionKeys
+// so far.
+func SessionFromContext(ctx context.Context) (sessions.Session, bool) {
+	rs := requestSessionFromContext(ctx)
+	if rs == nil {
+		return sessions.Session{}, false
+	}
+	return rs.snapshot(), true
+}
+
+// Returns the user id of the authenticated user for this request, and false if the request did not pass through
+// Authmiddleware or has a guest session.
+func UserIdFromContext(ctx context.Context) (string, bool) {
+	return requestSessionFromContext(ctx).userId()
+}
+
+// Returns the authenticated user for this request. The user is loaded from the AuthStore the first time this is
+// called for a request and reused afterwards. Returns ErrNoSessionInContext if the request did not pass through
+// Authmiddleware or has a guest session.
+func UserFromContext(ctx context.Context) (sessions.User, error) {
+	userId, ok := UserIdFromContext(ctx)
+	if !ok {
+		return sessions.User{}, ErrNoSessionInContext
+	}
+	loader, ok := ctx.Value(userContextKey).(*userLoader)
+	if !ok {
+		return sessions.User{}, ErrNoSessionInContext
+	}
+	return loader.load(ctx, userId)
+}
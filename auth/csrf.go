@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"strings"
+)
+
+// Settings for CSRFMiddleware.
+type CSRFConfig struct {
+	// The request header an SPA sends the token in, and the response header the token is exposed in.
+	HeaderName string
+	// The form field a server-rendered form sends the token in.
+	FormField string
+	// The name of a cookie, readable by JavaScript, that the token is also exposed in so an SPA can copy it into
+	// HeaderName. Leave empty to not set a cookie.
+	CookieName string
+	// Reports whether a request is exempt from CSRF checks. Nil exempts nothing.
+	Exempt func(*http.Request) bool
+}
+
+// Returns the default CSRF settings: the token is read from the X-CSRF-Token header or the csrf_token form field,
+// exposed in a csrf_token cookie, and requests authenticated with a bearer token are exempt.
+func DefaultCSRFConfig() CSRFConfig {
+	return CSRFConfig{
+		HeaderName: "X-CSRF-Token",
+		FormField:  "csrf_token",
+		CookieName: "csrf_token",
+		Exempt:     HasBearerToken,
+	}
+}
+
+// Reports whether the request carries a bearer token in its Authorization header. Browsers never attach such
+// headers on their own, so requests with one cannot be forged cross-site and are exempt by default.
+func HasBearerToken(r *http.Request) bool {
+	scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " ")
+	return found && strings.EqualFold(scheme, "Bearer") && token != ""
+}
+
+// Returns the CSRF token for the request's session, for embedding in forms rendered by handlers behind
+// CSRFMiddleware, or an empty string if there is no session.
+func CSRFToken(ctx context.Context) string {
+	token, _ := ctx.Value(csrfTokenContextKey).(string)
+	return token
+}
+
+// Sets or, given an empty token, clears the CSRF cookie when one is configured.
+func (ac *AuthContext) setCSRFCookie(w http.ResponseWriter, token string) {
+	if ac.CSRF.CookieName != "" {
+		http.SetCookie(w, ac.Cookie.CompanionCookie(ac.CSRF.CookieName, token))
+	}
+}
+
+// Returns the synchronizer token for a session. It is a MAC of the session id made with the keyring, so it can be
+// checked without storing anything and it does not reveal the session id.
+func (ac *AuthContext) CSRFTokenForSession(sessionId string) string {
+	return ac.Keys.Tag("csrf." + sessionId)
+}
+
+// A middleware that protects authenticated requests from cross-site request forgery. For every request with a
+// valid session cookie it makes the session's token available through CSRFToken, the response header and the CSRF
+// cookie, and it rejects requests with unsafe methods (anything but GET, HEAD, OPTIONS and TRACE) that do not send
+// the token back in the header or form field. Requests without a session have nothing to forge and pass through.
+//
+// It can be used on its own or after Authmiddleware.
+func (ac *AuthContext) CSRFMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ac.CSRF.Exempt != nil && ac.CSRF.Exempt(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		sessionId, ok := ac.requestSessionId(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := ac.CSRFTokenForSession(sessionId)
+		if !isSafeMethod(r.Method) && !ac.Keys.CheckTag("csrf."+sessionId, ac.submittedCSRFToken(r)) {
+			writeErrorCode(w, http.StatusForbidden, ErrCodeInvalidCSRFToken, "Missing or invalid CSRF token")
+			return
+		}
+
+		if ac.CSRF.HeaderName != "" {
+			w.Header().Set(ac.CSRF.HeaderName, token)
+		}
+		if c, err := r.Cookie(ac.Cookie.CompanionCookie(ac.CSRF.CookieName, "").Name); err != nil || c.Value != token {
+			ac.setCSRFCookie(w, token)
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), csrfTokenContextKey, token)))
+	})
+}
+
+// returns the id of the session Authmiddleware or GuestMiddleware put in the context, or else the one in a valid session cookie
+func (ac *AuthContext) requestSessionId(r *http.Request) (string, bool) {
+	if s, ok := SessionFromContext(r.Context()); ok {
+		return string(s.Id), true
+	}
+	sessionId, apiErr := ac.verifyRequest(r)
+	return sessionId, apiErr == nil
+}
+
+// returns the token the client sent back, from the header if present or else the form field
+func (ac *AuthContext) submittedCSRFToken(r *http.Request) string {
+	if ac.CSRF.HeaderName != "" {
+		if token := r.Header.Get(ac.CSRF.HeaderName); token != "" {
+			return token
+		}
+	}
+	if ac.CSRF.FormField != "" {
+		return r.PostFormValue(ac.CSRF.FormField)
+	}
+	return ""
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+func TestCSRFMiddleware(t *testing.T) {
+	store := NewMemoryAuthStore()
+	ac := NewAuthContext(store, sessions.NewKeyringFromSecret("test signing secret"), time.Hour)
+	cookie, sessionId := sessions.NewCookieWithSessionId(ac.Keys, time.Hour, ac.Cookie)
+	store.SaveSession(t.Context(), sessions.Session{Id: sessions.SessionId(sessionId), UserId: "01", CreatedAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)})
+
+	handler := ac.Authmiddleware(ac.CSRFMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(CSRFToken(r.Context())))
+	})))
+	serve := func(method string, token string, form string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/", strings.NewReader(form))
+		r.AddCookie(cookie)
+		if token != "" {
+			r.Header.Set("X-CSRF-Token", token)
+		}
+		if form != "" {
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	get := serve(http.MethodGet, "", "")
+	token := get.Body.String()
+	if get.Code != http.StatusOK || token == "" || get.Header().Get("X-CSRF-Token") != token {
+		t.Fatalf("GET returned %d with token %q and header %q", get.Code, token, get.Header().Get("X-CSRF-Token"))
+	}
+
+	if w := serve(http.MethodPost, "", ""); w.Code != http.StatusForbidden {
+		t.Errorf("POST without a token returned %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := serve(http.MethodPost, ac.CSRFTokenForSession("another session"), ""); w.Code != http.StatusForbidden {
+		t.Errorf("POST with another session's token returned %d, want %d", w.Code, http.StatusForbidden)
+	}
+	if w := serve(http.MethodPost, token, ""); w.Code != http.StatusOK {
+		t.Errorf("POST with the header token returned %d, want %d", w.Code, http.StatusOK)
+	}
+	if w := serve(http.MethodPost, "", "csrf_token="+token); w.Code != http.StatusOK {
+		t.Errorf("POST with the form token returned %d, want %d", w.Code, http.StatusOK)
+	}
+
+	// tokens keep working after the signing key is rotated
+	if err := ac.Keys.Rotate(sessions.Key{Id: "2", Secret: "new signing secret"}); err != nil {
+		t.Fatal(err)
+	}
+	if w := serve(http.MethodPost, token, ""); w.Code != http.StatusOK {
+		t.Errorf("POST with a token from before rotation returned %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,55 @@
+package auth
+
+import (
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// by default a session is renewed once half of its idle timeout has passed
+const defaultRenewAfter = 0.5
+
+// how stale a session's LastSeenAt can get before the middleware writes it back to the store
+const lastSeenInterval = time.Minute
+
+// returns the time by the clock, or by the system clock if it is nil
+func clockNow(clock sessions.Clock) time.Time {
+	if clock == nil {
+		return time.Now()
+	}
+	return clock.Now()
+}
+
+// Reports whether a session has passed its idle timeout or, when MaxLifetime is set, its absolute lifetime.
+func (ac *AuthContext) sessionExpired(s sessions.Session, now time.Time) bool {
+	if now.After(s.ExpiresAt) {
+		return true
+	}
+	return ac.MaxLifetime > 0 && now.After(s.CreatedAt.Add(ac.MaxLifetime))
+}
+
+// Reports whether enough of the idle timeout has elapsed since the session was last renewed for it to be extended,
+// and whether extending it would actually move the expiry forward.
+func (ac *AuthContext) shouldRenew(s sessions.Session, now time.Time) bool {
+	if ac.RenewAfter <= 0 || ac.Duration <= 0 {
+		return false
+	}
+	lastRenewed := s.ExpiresAt.Add(-ac.Duration)
+	threshold := time.Duration(float64(ac.Duration) * ac.RenewAfter)
+	if now.Sub(lastRenewed) < threshold {
+		return false
+	}
+	return ac.renewedExpiry(s, now).After(s.ExpiresAt)
+}
+
+// Returns the new expiry for a renewed session, which is one idle timeout from now capped at the absolute lifetime.
+func (ac *AuthContext) renewedExpiry(s sessions.Session, now time.Time) time.Time {
+	expiresAt := now.Add(ac.Duration)
+	if ac.MaxLifetime > 0 {
+		deadline := s.CreatedAt.Add(ac.MaxLifetime)
+		if expiresAt.After(deadline) {
+			expiresAt = deadline
+		}
+	}
+	return expiresAt
+}
@@ -0,0 +1,81 @@
+package auth
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// A middleware that gives every visitor a session, issuing a signed anonymous one to visitors without a valid
+// session cookie, so that state such as a cart can be kept with SessionKeys before they log in. Logged-in users
+// pass through with their own session. Guest sessions have an empty UserId, and UserIdFromContext and
+// UserFromContext report that there is no user for them.
+//
+// When a guest logs in or registers, LoginHandler and RegisterHandler promote their session: it is replaced by an
+// authenticated session with a new id, which keeps any id planted by an attacker before login from being worth
+// anything after it, and the session's data is carried over. Authmiddleware turns guest sessions away, so it can be
+// used behind this middleware for the routes that need a logged-in user.
+//
+// Every new visitor gets a session in the store, including crawlers, so use WithSessionReaper to clear out the
+// ones that expire unused.
+func (ac *AuthContext) GuestMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nSession, apiErr := ac.loadRequestSession(w, r)
+		if apiErr == internalError {
+			writeError(w, apiErr)
+			return
+		}
+		if apiErr != nil {
+			var ok bool
+			nSession, ok = ac.startGuestSession(w, r)
+			if !ok {
+				return
+			}
+		}
+
+		ctx, rs := ac.withSession(r.Context(), nSession)
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+
+		ac.saveSessionData(r.Context(), rs)
+	})
+}
+
+// Creates and stores a new guest session and sets its cookies on the response. If the session cannot be saved an
+// error response is written and false is returned.
+func (ac *AuthContext) startGuestSession(w http.ResponseWriter, r *http.Request) (sessions.Session, bool) {
+	nSession, cookie := ac.newSession(r, "")
+	if err := ac.Sessions.SaveSession(r.Context(), nSession); err != nil {
+		log.Printf("Error inserting guest session into DB: %s", err)
+		writeError(w, internalError)
+		return nSession, false
+	}
+	http.SetCookie(w, cookie)
+	ac.setCSRFCookie(w, ac.CSRFTokenForSession(string(nSession.Id)))
+	return nSession, true
+}
+
+// Returns the guest session a request to log in or register was made with, from GuestMiddleware if the request
+// passed through it or else from the request's session cookie, and false if there is none. The session carried by
+// the request's context is returned as well, if there is one.
+func (ac *AuthContext) requestGuestSession(r *http.Request) (sessions.Session, *requestSession, bool) {
+	if rs := requestSessionFromContext(r.Context()); rs != nil {
+		s := rs.snapshot()
+		return s, rs, s.UserId == ""
+	}
+	sessionId, apiErr := ac.verifyRequest(r)
+	if apiErr != nil {
+		return sessions.Session{}, nil, false
+	}
+	s, err := ac.Sessions.LoadSessionById(r.Context(), sessionId)
+	if err != nil {
+		if !errors.Is(err, sessions.ErrSessionNotFound) {
+			// the login goes ahead with a fresh session, only without the guest's data
+			log.Printf("Error loading guest session: %s", err)
+		}
+		return s, nil, false
+	}
+	return s, nil, s.UserId == "" && !ac.sessionExpired(s, ac.Clock.Now())
+}
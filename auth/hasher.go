@@ -0,0 +1,255 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var ErrUnrecognizedPasswordHash = errors.New("The stored password hash was not produced by any configured hasher")
+
+var ErrMalformedPasswordHash = errors.New("The stored password hash is malformed")
+
+// A PasswordHasher hashes passwords for storage and checks passwords against stored hashes. Hashes are encoded as
+// self-describing strings (PHC strings for argon2id, modular crypt strings for bcrypt) that record the algorithm
+// and parameters used, so hashes from several hashers can live side by side in the user store.
+type PasswordHasher interface {
+	// Returns the encoded hash of the password.
+	Hash(password string) (string, error)
+	// Reports whether the encoded hash was produced by this kind of hasher.
+	Recognizes(encoded string) bool
+	// Reports whether the password matches the encoded hash.
+	Verify(password string, encoded string) (bool, error)
+	// Reports whether the encoded hash was made with weaker parameters than this hasher currently uses.
+	NeedsRehash(encoded string) bool
+}
+
+// Hashes passwords with bcrypt. Bcrypt only looks at the first 72 bytes of a password, so Hash rejects longer
+// passwords rather than silently truncating them.
+type BcryptHasher struct {
+	Cost int
+}
+
+// Returns a bcrypt hasher with bcrypt.DefaultCost.
+func NewBcryptHasher() *BcryptHasher {
+	return &BcryptHasher{Cost: bcrypt.DefaultCost}
+}
+
+func (b *BcryptHasher) Hash(password string) (string, error) {
+	bts, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	if err != nil {
+		return "", err
+	}
+	return string(bts), nil
+}
+
+func (b *BcryptHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$2a$") || strings.HasPrefix(encoded, "$2b$") || strings.HasPrefix(encoded, "$2y$")
+}
+
+func (b *BcryptHasher) Verify(password string, encoded string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), []byte(password))
+	if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *BcryptHasher) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost < b.Cost
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// Hashes passwords with argon2id and encodes them as PHC strings of the form
+//
+// $argon2id$v=19$m=19456,t=2,p=1$<salt>$<hash>
+//
+// where the salt and hash are unpadded standard base64.
+type Argon2idHasher struct {
+	// Memory in KiB
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// Returns an argon2id hasher with the parameters recommended by OWASP: 19 MiB of memory, 2 iterations and a
+// parallelism of 1.
+func NewArgon2idHasher() *Argon2idHasher {
+	return &Argon2idHasher{
+		Memory:      19 * 1024,
+		Iterations:  2,
+		Parallelism: 1,
+		SaltLength:  16,
+		KeyLength:   32,
+	}
+}
+
+func (a *Argon2idHasher) Hash(password string) (string, error) {
+	salt := make([]byte, a.SaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2idPrefix, argon2.Version, a.Memory, a.Iterations, a.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
+}
+
+func (a *Argon2idHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, argon2idPrefix)
+}
+
+func (a *Argon2idHasher) Verify(password string, encoded string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return false, err
+	}
+	candidate := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+	return subtle.ConstantTimeCompare(candidate, key) == 1, nil
+}
+
+func (a *Argon2idHasher) NeedsRehash(encoded string) bool {
+	params, salt, key, err := decodeArgon2id(encoded)
+	if err != nil {
+		return true
+	}
+	return params.Memory < a.Memory ||
+		params.Iterations < a.Iterations ||
+		params.Parallelism < a.Parallelism ||
+		uint32(len(salt)) < a.SaltLength ||
+		uint32(len(key)) < a.KeyLength
+}
+
+// parses an argon2id PHC string into its parameters, salt and key
+func decodeArgon2id(encoded string) (Argon2idHasher, []byte, []byte, error) {
+	var params Argon2idHasher
+	parts := strings.Split(encoded, "$")
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return params, nil, nil, ErrMalformedPasswordHash
+	}
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return params, nil, nil, ErrMalformedPasswordHash
+	}
+	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil || params.Iterations == 0 || params.Parallelism == 0 {
+		return params, nil, nil, ErrMalformedPasswordHash
+	}
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return params, nil, nil, ErrMalformedPasswordHash
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return params, nil, nil, ErrMalformedPasswordHash
+	}
+	return params, salt, key, nil
+}
+
+// Checks a password against a stored hash using whichever configured hasher recognizes it. It also reports whether
+// the hash should be replaced with one from the preferred hasher, either because it was made by a legacy hasher
+// or because the preferred hasher's parameters have since been raised.
+func (ac *AuthContext) verifyPassword(password string, encoded string) (ok bool, needsRehash bool, err error) {
+	if ac.Hasher.Recognizes(encoded) {
+		ok, err = ac.Hasher.Verify(password, encoded)
+		return ok, ok && ac.Hasher.NeedsRehash(encoded), err
+	}
+	for _, legacy := range ac.LegacyHashers {
+		if legacy.Recognizes(encoded) {
+			ok, err = legacy.Verify(password, encoded)
+			return ok, ok, err
+		}
+	}
+	return false, false, ErrUnrecognizedPasswordHash
+}
+
+// An argon2id hash, with NewArgon2idHasher's parameters, of a random password that was thrown away. Logins for
+// unknown usernames are checked against it when none of the configured hashers can hash.
+const fallbackDummyPasswordHash = "$argon2id$v=19$m=19456,t=2,p=1$bVLicq6t+A0DMppM5B6rlA$hY0fuuKJUcCGfHsWWr9D4Ib2bijCH2iap5l/OsuwpG0"
+
+// how many times each hasher is timed rejecting a wrong password when picking the slowest, of which the median counts
+const dummyHashSamples = 5
+
+// a hasher that might make the dummy hash, with its hash and how long it took to reject a wrong password
+type dummyHashCandidate struct {
+	hasher PasswordHasher
+	hash   string
+	took   []time.Duration
+}
+
+func (c dummyHashCandidate) median() time.Duration {
+	if len(c.took) == 0 {
+		return 0
+	}
+	took := slices.Clone(c.took)
+	slices.Sort(took)
+	return took[len(took)/2]
+}
+
+// Returns a hash of a random password from the pinned hasher if there is one, and otherwise from whichever
+// configured hasher takes longest to reject a wrong password, along with that hasher. The hashers are timed several
+// times, taking turns, and compared by their median, so that a single slow sample at startup doesn't decide. Users
+// whose hashes were made by a faster legacy hasher still fail a login sooner than unknown usernames do, until their
+// hash is upgraded at their next successful login. Hashers that can't hash, for example bcrypt with a cost above 31,
+// are logged and left out, and if none can, fallbackDummyPasswordHash is used.
+func newDummyPasswordHash(pinned PasswordHasher, preferred PasswordHasher, legacy []PasswordHasher) (PasswordHasher, string) {
+	password := make([]byte, 32)
+	rand.Read(password)
+	encoded := base64.RawStdEncoding.EncodeToString(password)
+
+	hashers := append([]PasswordHasher{preferred}, legacy...)
+	if pinned != nil {
+		hashers = []PasswordHasher{pinned}
+	}
+	var candidates []dummyHashCandidate
+	for _, h := range hashers {
+		hashed, err := h.Hash(encoded)
+		if err != nil {
+			log.Printf("Password hasher %T can't hash, so unknown usernames are not timed against it: %s", h, err)
+			continue
+		}
+		candidates = append(candidates, dummyHashCandidate{hasher: h, hash: hashed})
+	}
+	if len(candidates) == 0 {
+		log.Printf("No password hasher can hash, so unknown usernames are checked against a fixed argon2id hash")
+		return NewArgon2idHasher(), fallbackDummyPasswordHash
+	}
+	if len(candidates) > 1 {
+		for range dummyHashSamples {
+			for i := range candidates {
+				candidates[i].took = append(candidates[i].took, timeWrongPassword(candidates[i].hasher, candidates[i].hash))
+			}
+		}
+	}
+	slowest := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.median() > slowest.median() {
+			slowest = c
+		}
+	}
+	return slowest.hasher, slowest.hash
+}
+
+// returns how long the hasher takes to reject a wrong password for the hash
+func timeWrongPassword(h PasswordHasher, hashed string) time.Duration {
+	start := time.Now()
+	h.Verify("", hashed)
+	return time.Since(start)
+}
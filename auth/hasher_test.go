@@ -0,0 +1,60 @@
+package auth
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestArgon2idHasher(t *testing.T) {
+	h := &Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	long := strings.Repeat("a", 100) + "b"
+
+	encoded, err := h.Hash(long)
+	if err != nil {
+		t.Fatalf("Hash() error = %v", err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("Hash() = %q, want a PHC string with the hasher's parameters", encoded)
+	}
+	// passwords past bcrypt's 72 byte limit must still be compared in full
+	if ok, err := h.Verify(strings.Repeat("a", 100)+"c", encoded); ok || err != nil {
+		t.Errorf("Verify(wrong password) = %v, %v, want false, nil", ok, err)
+	}
+	if ok, err := h.Verify(long, encoded); !ok || err != nil {
+		t.Errorf("Verify(password) = %v, %v, want true, nil", ok, err)
+	}
+
+	if h.NeedsRehash(encoded) {
+		t.Error("NeedsRehash() = true for a hash made with the current parameters")
+	}
+	stronger := *h
+	stronger.Iterations = 2
+	if !stronger.NeedsRehash(encoded) {
+		t.Error("NeedsRehash() = false after raising the iteration count")
+	}
+}
+
+func TestVerifyPasswordUpgradesLegacyHashes(t *testing.T) {
+	ac := &AuthContext{
+		Hasher:        &Argon2idHasher{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32},
+		LegacyHashers: []PasswordHasher{&BcryptHasher{Cost: bcrypt.MinCost}},
+	}
+	legacy, err := ac.LegacyHashers[0].Hash("correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ok, needsRehash, err := ac.verifyPassword("correct horse", legacy)
+	if !ok || !needsRehash || err != nil {
+		t.Errorf("verifyPassword(legacy hash) = %v, %v, %v, want true, true, nil", ok, needsRehash, err)
+	}
+	ok, needsRehash, err = ac.verifyPassword("wrong horse", legacy)
+	if ok || needsRehash || err != nil {
+		t.Errorf("verifyPassword(wrong password) = %v, %v, %v, want false, false, nil", ok, needsRehash, err)
+	}
+	if _, _, err := ac.verifyPassword("correct horse", "plaintext"); err != ErrUnrecognizedPasswordHash {
+		t.Errorf("verifyPassword(unknown hash) error = %v, want %v", err, ErrUnrecognizedPasswordHash)
+	}
+}
@@ -0,0 +1,230 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// a hasher that takes a fixed amount of time to verify and counts how often it is asked to
+type slowHasher struct {
+	delay    time.Duration
+	verifies atomic.Int32
+}
+
+func (h *slowHasher) Hash(password string) (string, error) {
+	return "$slow$" + password, nil
+}
+
+func (h *slowHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$slow$")
+}
+
+func (h *slowHasher) Verify(password string, encoded string) (bool, error) {
+	h.verifies.Add(1)
+	time.Sleep(h.delay)
+	return encoded == "$slow$"+password, nil
+}
+
+func (h *slowHasher) NeedsRehash(string) bool {
+	return false
+}
+
+func TestLoginFailuresAreIndistinguishable(t *testing.T) {
+	hasher := &slowHasher{delay: 20 * time.Millisecond}
+	store := NewMemoryAuthStore()
+	store.SaveUser(t.Context(), sessions.User{UserId: "01", Username: "alice", HashedPassword: "$slow$correct horse"})
+	ac := NewAuthContext(store, sessions.NewKeyringFromSecret("test signing secret"), time.Hour, WithPasswordHasher(hasher))
+
+	login := func(username, password string) (*httptest.ResponseRecorder, time.Duration) {
+		body := `{"username":"` + username + `","password":"` + password + `"}`
+		r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		start := time.Now()
+		ac.LoginHandler(w, r)
+		return w, time.Since(start)
+	}
+
+	wrongPassword, wrongPasswordTook := login("alice", "wrong horse")
+	before := hasher.verifies.Load()
+	unknownUser, unknownUserTook := login("mallory", "wrong horse")
+
+	if wrongPassword.Code != http.StatusUnauthorized || unknownUser.Code != wrongPassword.Code {
+		t.Errorf("status codes = %d (wrong password) and %d (unknown user), want both %d",
+			wrongPassword.Code, unknownUser.Code, http.StatusUnauthorized)
+	}
+	if unknownUser.Body.String() != wrongPassword.Body.String() {
+		t.Errorf("bodies differ:\nwrong password: %s\nunknown user:   %s", wrongPassword.Body, unknownUser.Body)
+	}
+	if unknownUser.Header().Get("Content-Type") != wrongPassword.Header().Get("Content-Type") {
+		t.Errorf("content types differ: %q and %q",
+			wrongPassword.Header().Get("Content-Type"), unknownUser.Header().Get("Content-Type"))
+	}
+	if hasher.verifies.Load() != before+1 {
+		t.Error("login for an unknown user did not perform a password comparison")
+	}
+	for name, took := range map[string]time.Duration{"wrong password": wrongPasswordTook, "unknown user": unknownUserTook} {
+		if took < hasher.delay {
+			t.Errorf("%s login took %s, want at least the %s a password comparison takes", name, took, hasher.delay)
+		}
+	}
+
+	if ok, _ := login("alice", "correct horse"); ok.Code != http.StatusOK {
+		t.Errorf("login with the correct password status = %d, want %d", ok.Code, http.StatusOK)
+	}
+}
+
+// a slowHasher with hashes of its own, standing in for a legacy hasher like bcrypt
+type legacySlowHasher struct {
+	slowHasher
+}
+
+func (h *legacySlowHasher) Hash(password string) (string, error) {
+	return "$legacy$" + password, nil
+}
+
+func (h *legacySlowHasher) Recognizes(encoded string) bool {
+	return strings.HasPrefix(encoded, "$legacy$")
+}
+
+func (h *legacySlowHasher) Verify(password string, encoded string) (bool, error) {
+	return h.slowHasher.Verify(password, "$slow$"+strings.TrimPrefix(encoded, "$legacy$"))
+}
+
+func TestUnknownUsersTakeAsLongAsTheSlowestHasher(t *testing.T) {
+	legacy := &legacySlowHasher{slowHasher{delay: 20 * time.Millisecond}}
+	store := NewMemoryAuthStore()
+	ac := NewAuthContext(store, sessions.NewKeyringFromSecret("test signing secret"), time.Hour,
+		WithPasswordHasher(&slowHasher{}, legacy))
+	if ac.dummyHasher != legacy || !legacy.Recognizes(ac.dummyHash) {
+		t.Errorf("the dummy hash %q was not made by the slower legacy hasher", ac.dummyHash)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"mallory","password":"wrong horse"}`))
+	r.Header.Set("Content-Type", "application/json")
+	start := time.Now()
+	ac.LoginHandler(httptest.NewRecorder(), r)
+	if took := time.Since(start); took < legacy.delay {
+		t.Errorf("login for an unknown user took %s, want at least the %s the legacy hasher takes", took, legacy.delay)
+	}
+}
+
+func TestWithDummyPasswordHasher(t *testing.T) {
+	pinned := &legacySlowHasher{}
+	ac := NewAuthContext(NewMemoryAuthStore(), sessions.NewKeyringFromSecret("test signing secret"), time.Hour,
+		WithPasswordHasher(&slowHasher{delay: 20 * time.Millisecond}, pinned), WithDummyPasswordHasher(pinned))
+	if ac.dummyHasher != pinned || !pinned.Recognizes(ac.dummyHash) {
+		t.Errorf("the dummy hash %q was not made by the pinned hasher, even though another one is slower", ac.dummyHash)
+	}
+}
+
+func TestDummyHashWithoutAWorkingHasher(t *testing.T) {
+	// a bcrypt cost above 31 can't hash, which mustn't stop the server or let unknown usernames answer instantly
+	ac := NewAuthContext(NewMemoryAuthStore(), sessions.NewKeyringFromSecret("test signing secret"), time.Hour,
+		WithPasswordHasher(&BcryptHasher{Cost: 32}))
+	if _, ok := ac.dummyHasher.(*Argon2idHasher); !ok || ac.dummyHash != fallbackDummyPasswordHash {
+		t.Fatalf("the dummy hash is %q from %T, want the fixed argon2id one", ac.dummyHash, ac.dummyHasher)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"mallory","password":"wrong horse"}`))
+	r.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	ac.LoginHandler(w, r)
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("login for an unknown user returned %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestLegacyUsernameCollision(t *testing.T) {
+	store := NewMemoryAuthStore()
+	// stored before usernames were normalized
+	store.SaveUser(t.Context(), sessions.User{UserId: "legacy", Username: "Alice", HashedPassword: "$slow$legacy horse"})
+	ac := NewAuthContext(store, sessions.NewKeyringFromSecret("test signing secret"), time.Hour, WithPasswordHasher(&slowHasher{}))
+
+	post := func(handler http.HandlerFunc, username, password string) *httptest.ResponseRecorder {
+		body := `{"username":"` + username + `","password":"` + password + `"}`
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, r)
+		return w
+	}
+	loggedInAs := func(username, password string) string {
+		t.Helper()
+		w := post(ac.LoginHandler, username, password)
+		if w.Code != http.StatusOK {
+			return ""
+		}
+		sessionId, _ := sessions.VerifySessionId(w.Result().Cookies()[0].Value, ac.Keys)
+		s, _ := store.LoadSessionById(t.Context(), sessionId)
+		return s.UserId
+	}
+
+	if w := post(ac.RegisterHandler, "Alice", "correct horse battery"); w.Code != http.StatusConflict {
+		t.Errorf("registering the legacy name returned %d, want %d", w.Code, http.StatusConflict)
+	}
+	// a variant of the legacy name that only matches it once normalized can be registered
+	if w := post(ac.RegisterHandler, "ALICE", "correct horse battery"); w.Code != http.StatusCreated {
+		t.Fatalf("registering ALICE returned %d: %s", w.Code, w.Body)
+	}
+
+	if got := loggedInAs("Alice", "legacy horse"); got != "legacy" {
+		t.Errorf("logging in as Alice reached %q, want the legacy account", got)
+	}
+	if got := loggedInAs("Alice", "correct horse battery"); got != "" {
+		t.Errorf("logging in as Alice with the new account's password reached %q", got)
+	}
+	if got := loggedInAs("alice", "correct horse battery"); got == "" || got == "legacy" {
+		t.Errorf("logging in as alice reached %q, want the new account", got)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	store := NewMemoryAuthStore()
+	store.SaveUser(t.Context(), sessions.User{UserId: "01", Username: "alice", HashedPassword: "$slow$correct horse"})
+	limiter := NewMemoryLimiter(LimiterConfig{Burst: 100, RefillEvery: time.Second, MaxFailures: 3, Lockout: time.Minute, MaxLockout: time.Hour})
+	ac := NewAuthContext(store, sessions.NewKeyringFromSecret("test signing secret"), time.Hour,
+		WithPasswordHasher(&slowHasher{}), WithLoginLimiter(limiter))
+
+	login := func(password string) *httptest.ResponseRecorder {
+		body := `{"username":"alice","password":"` + password + `"}`
+		r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		ac.LoginHandler(w, r)
+		return w
+	}
+
+	for i := 0; i < 3; i++ {
+		if w := login("wrong horse"); w.Code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d status = %d, want %d", i+1, w.Code, http.StatusUnauthorized)
+		}
+	}
+	w := login("correct horse")
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status after lockout = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if got := w.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want %q", got, "60")
+	}
+
+	if err := ac.UnlockUsername(context.Background(), "alice"); err != nil {
+		t.Fatalf("UnlockUsername() error = %v", err)
+	}
+	// the client IP is still locked out after failing against alice
+	if w := login("correct horse"); w.Code != http.StatusTooManyRequests {
+		t.Fatalf("status with the IP still locked = %d, want %d", w.Code, http.StatusTooManyRequests)
+	}
+	if err := ac.UnlockIP(context.Background(), "192.0.2.1"); err != nil {
+		t.Fatalf("UnlockIP() error = %v", err)
+	}
+	if w := login("correct horse"); w.Code != http.StatusOK {
+		t.Errorf("status after unlocking = %d, want %d", w.Code, http.StatusOK)
+	}
+}
@@ -0,0 +1,510 @@
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+var ErrUnsupportedSnapshot = errors.New("The snapshot was written by an unsupported version of the memory store")
+
+// An AuthStore and LoginAttemptStore that keeps everything in memory. It is safe for concurrent use and returns the
+// same errors as the SQL stores, which makes it suitable for tests and for applications that run as a single
+// process. Its contents can be written to a file with SaveSnapshot and read back with LoadSnapshot, for example on
+// shutdown and startup.
+type MemoryAuthStore struct {
+	mu        sync.Mutex
+	users     map[string]sessions.User
+	usernames map[string]string // username to user id
+	sessions  map[string]sessions.Session
+	attempts  map[string]sessions.LoginAttempts
+
+	evict  bool
+	ttl    time.Duration
+	writes int
+	clock  sessions.Clock
+}
+
+// A MemoryStoreOption configures optional behavior of a MemoryAuthStore when passed to NewMemoryAuthStore.
+type MemoryStoreOption func(*MemoryAuthStore)
+
+// Makes the store evict sessions ttl after they expire and login attempts ttl after they were last updated or their
+// lockout ended, whichever is later, so that a long-running process doesn't accumulate them. Evicted entries are dropped when they are accessed and in a sweep
+// every few hundred writes. Without this option expired sessions stay until they are deleted, as in the SQL stores.
+func WithEvictionTTL(ttl time.Duration) MemoryStoreOption {
+	return func(m *MemoryAuthStore) {
+		m.evict = true
+		m.ttl = ttl
+	}
+}
+
+// Sets the clock that decides when entries are evicted, for example a fake clock in tests.
+func WithEvictionClock(clock sessions.Clock) MemoryStoreOption {
+	return func(m *MemoryAuthStore) {
+		m.clock = clock
+	}
+}
+
+// Returns a new, empty in-memory store.
+func NewMemoryAuthStore(opts ...MemoryStoreOption) *MemoryAuthStore {
+	m := &MemoryAuthStore{clock: sessions.SystemClock{}}
+	m.reset()
+	for _, opt := range opts {
+		opt(m)
+	}
+	return m
+}
+
+// how many writes go by between sweeps for evicted entries
+const memoryStoreSweepEvery = 256
+
+// empties the store. Must be called with the lock held.
+func (m *MemoryAuthStore) reset() {
+	m.users = make(map[string]sessions.User)
+	m.usernames = make(map[string]string)
+	m.sessions = make(map[string]sessions.Session)
+	m.attempts = make(map[string]sessions.LoginAttempts)
+}
+
+func (m *MemoryAuthStore) sessionEvicted(s sessions.Session, now time.Time) bool {
+	return m.evict && !now.Before(s.ExpiresAt.Add(m.ttl))
+}
+
+// a lockout is kept until it ends, like in RedisAuthStore, however short the ttl
+func (m *MemoryAuthStore) attemptsEvicted(a sessions.LoginAttempts, now time.Time) bool {
+	last := a.UpdatedAt
+	if a.LockedUntil.After(last) {
+		last = a.LockedUntil
+	}
+	return m.evict && !now.Before(last.Add(m.ttl))
+}
+
+// counts a write and sweeps for evicted entries every so often. Must be called with the lock held.
+func (m *MemoryAuthStore) wrote() {
+	m.writes++
+	if !m.evict || m.writes%memoryStoreSweepEvery != 0 {
+		return
+	}
+	now := m.clock.Now()
+	for id, s := range m.sessions {
+		if m.sessionEvicted(s, now) {
+			delete(m.sessions, id)
+		}
+	}
+	for key, a := range m.attempts {
+		if m.attemptsEvicted(a, now) {
+			delete(m.attempts, key)
+		}
+	}
+}
+
+func (m *MemoryAuthStore) SaveUser(ctx context.Context, u sessions.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.users[u.UserId]; exists {
+		return sessions.ErrUserExists
+	}
+	if _, taken := m.usernames[u.Username]; taken {
+		return sessions.ErrUserExists
+	}
+	m.users[u.UserId] = u
+	m.usernames[u.Username] = u.UserId
+	m.wrote()
+	return nil
+}
+
+func (m *MemoryAuthStore) LoadUserByUserId(ctx context.Context, id string) (sessions.User, error) {
+	if err := ctx.Err(); err != nil {
+		return sessions.User{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return sessions.User{UserId: id}, sessions.ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (m *MemoryAuthStore) LoadUserByUsername(ctx context.Context, username string) (sessions.User, error) {
+	if err := ctx.Err(); err != nil {
+		return sessions.User{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	id, ok := m.usernames[username]
+	if !ok {
+		return sessions.User{Username: username}, sessions.ErrUserNotFound
+	}
+	return m.users[id], nil
+}
+
+// Updates the username and hashed password of an existing user
+func (m *MemoryAuthStore) UpdateUser(ctx context.Context, u sessions.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.users[u.UserId]
+	if !ok {
+		return sessions.ErrUserNotFound
+	}
+	if owner, taken := m.usernames[u.Username]; taken && owner != u.UserId {
+		return sessions.ErrUserExists
+	}
+	delete(m.usernames, old.Username)
+	m.users[u.UserId] = u
+	m.usernames[u.Username] = u.UserId
+	m.wrote()
+	return nil
+}
+
+// Deletes a user and all of their sessions
+func (m *MemoryAuthStore) DeleteUserByUserId(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	u, ok := m.users[id]
+	if !ok {
+		return sessions.ErrUserNotFound
+	}
+	delete(m.users, id)
+	delete(m.usernames, u.Username)
+	for sid, s := range m.sessions {
+		if s.UserId == id {
+			delete(m.sessions, sid)
+		}
+	}
+	m.wrote()
+	return nil
+}
+
+func (m *MemoryAuthStore) SaveSession(ctx context.Context, s sessions.Session) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.sessions[string(s.Id)]; exists {
+		return sessions.ErrSessionExists
+	}
+	s.Data = s.Data.Clone()
+	m.sessions[string(s.Id)] = s
+	m.wrote()
+	return nil
+}
+
+// Deletes the session with the old id, if it still exists, and saves the new session in its place.
+func (m *MemoryAuthStore) ReplaceSession(ctx context.Context, oldSessionId string, s sessions.Session) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.sessions[string(s.Id)]; exists {
+		return sessions.ErrSessionExists
+	}
+	delete(m.sessions, oldSessionId)
+	s.Data = s.Data.Clone()
+	m.sessions[string(s.Id)] = s
+	m.wrote()
+	return nil
+}
+
+func (m *MemoryAuthStore) LoadSessionById(ctx context.Context, id string) (sessions.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return sessions.Session{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if ok && m.sessionEvicted(s, m.clock.Now()) {
+		delete(m.sessions, id)
+		ok = false
+	}
+	if !ok {
+		return sessions.Session{Id: sessions.SessionId(id)}, sessions.ErrSessionNotFound
+	}
+	s.Data = s.Data.Clone()
+	return s, nil
+}
+
+// Updates the expiry, last seen time and data of an existing session
+func (m *MemoryAuthStore) UpdateSession(ctx context.Context, s sessions.Session) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.sessions[string(s.Id)]
+	if !ok {
+		return sessions.ErrSessionNotFound
+	}
+	stored.ExpiresAt = s.ExpiresAt
+	stored.LastSeenAt = s.LastSeenAt
+	stored.Data = s.Data.Clone()
+	m.sessions[string(s.Id)] = stored
+	m.wrote()
+	return nil
+}
+
+// Replaces the data of an existing session, leaving its expiry and last seen time as they are
+func (m *MemoryAuthStore) UpdateSessionData(ctx context.Context, id string, data sessions.SessionData) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stored, ok := m.sessions[id]
+	if !ok {
+		return sessions.ErrSessionNotFound
+	}
+	stored.Data = data.Clone()
+	m.sessions[id] = stored
+	m.wrote()
+	return nil
+}
+
+func (m *MemoryAuthStore) DeleteSessionById(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.sessions[id]; !ok {
+		return sessions.ErrSessionNotFound
+	}
+	delete(m.sessions, id)
+	m.wrote()
+	return nil
+}
+
+// Returns all sessions belonging to a user, oldest first
+func (m *MemoryAuthStore) ListSessionsByUserId(ctx context.Context, userId string) ([]sessions.Session, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.clock.Now()
+	var list []sessions.Session
+	for _, s := range m.sessions {
+		if s.UserId == userId && !m.sessionEvicted(s, now) {
+			s.Data = s.Data.Clone()
+			list = append(list, s)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].Id < list[j].Id
+	})
+	return list, nil
+}
+
+// Deletes all sessions belonging to a user except the given one
+func (m *MemoryAuthStore) DeleteSessionsByUserId(ctx context.Context, userId string, exceptSessionId string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for sid, s := range m.sessions {
+		if s.UserId == userId && sid != exceptSessionId {
+			delete(m.sessions, sid)
+		}
+	}
+	m.wrote()
+	return nil
+}
+
+// Deletes up to limit sessions that expired before the given time, returning how many were deleted
+func (m *MemoryAuthStore) DeleteExpiredSessions(ctx context.Context, before time.Time, limit int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for sid, s := range m.sessions {
+		if limit > 0 && deleted == limit {
+			break
+		}
+		if s.ExpiresAt.Before(before) {
+			delete(m.sessions, sid)
+			deleted++
+		}
+	}
+	m.wrote()
+	return deleted, nil
+}
+
+// Loads the login throttling state for a key, returning an empty state if there is none
+func (m *MemoryAuthStore) LoadLoginAttempts(ctx context.Context, key string) (sessions.LoginAttempts, error) {
+	if err := ctx.Err(); err != nil {
+		return sessions.LoginAttempts{Key: key}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a, ok := m.attempts[key]
+	if !ok || m.attemptsEvicted(a, m.clock.Now()) {
+		delete(m.attempts, key)
+		return sessions.LoginAttempts{Key: key}, nil
+	}
+	return a, nil
+}
+
+// Inserts or replaces the login throttling state for a key
+func (m *MemoryAuthStore) SaveLoginAttempts(ctx context.Context, a sessions.LoginAttempts) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.attempts[a.Key] = a
+	m.wrote()
+	return nil
+}
+
+// Deletes the login throttling state for a key
+func (m *MemoryAuthStore) DeleteLoginAttempts(ctx context.Context, key string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.attempts, key)
+	m.wrote()
+	return nil
+}
+
+// Deletes up to limit keys whose last attempt and lockout both ended before the given time, returning how many were
+// deleted
+func (m *MemoryAuthStore) DeleteLoginAttemptsBefore(ctx context.Context, before time.Time, limit int) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deleted := 0
+	for key, a := range m.attempts {
+		if limit > 0 && deleted == limit {
+			break
+		}
+		if a.UpdatedAt.Before(before) && a.LockedUntil.Before(before) {
+			delete(m.attempts, key)
+			deleted++
+		}
+	}
+	m.wrote()
+	return deleted, nil
+}
+
+// the version of the snapshot format written by Snapshot
+const memorySnapshotVersion = 1
+
+// the contents of a MemoryAuthStore as written by Snapshot
+type memorySnapshot struct {
+	Version       int                      `json:"version"`
+	Users         []sessions.User          `json:"users"`
+	Sessions      []sessions.Session       `json:"sessions"`
+	LoginAttempts []sessions.LoginAttempts `json:"login_attempts"`
+}
+
+// Writes the contents of the store to w as JSON. The snapshot includes password hashes and session ids, so it must
+// be kept as private as the store itself.
+func (m *MemoryAuthStore) Snapshot(w io.Writer) error {
+	m.mu.Lock()
+	snap := memorySnapshot{Version: memorySnapshotVersion}
+	for _, u := range m.users {
+		snap.Users = append(snap.Users, u)
+	}
+	for _, s := range m.sessions {
+		snap.Sessions = append(snap.Sessions, s)
+	}
+	for _, a := range m.attempts {
+		snap.LoginAttempts = append(snap.LoginAttempts, a)
+	}
+	m.mu.Unlock()
+
+	sort.Slice(snap.Users, func(i, j int) bool { return snap.Users[i].UserId < snap.Users[j].UserId })
+	sort.Slice(snap.Sessions, func(i, j int) bool { return snap.Sessions[i].Id < snap.Sessions[j].Id })
+	sort.Slice(snap.LoginAttempts, func(i, j int) bool { return snap.LoginAttempts[i].Key < snap.LoginAttempts[j].Key })
+	return json.NewEncoder(w).Encode(snap)
+}
+
+// Replaces the contents of the store with a snapshot written by Snapshot. If the snapshot cannot be read the store
+// is left as it was.
+func (m *MemoryAuthStore) Restore(r io.Reader) error {
+	var snap memorySnapshot
+	if err := json.NewDecoder(r).Decode(&snap); err != nil {
+		return err
+	}
+	if snap.Version != memorySnapshotVersion {
+		return ErrUnsupportedSnapshot
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.reset()
+	for _, u := range snap.Users {
+		m.users[u.UserId] = u
+		m.usernames[u.Username] = u.UserId
+	}
+	for _, s := range snap.Sessions {
+		m.sessions[string(s.Id)] = s
+	}
+	for _, a := range snap.LoginAttempts {
+		m.attempts[a.Key] = a
+	}
+	return nil
+}
+
+// Writes a snapshot of the store to the file at path, readable only by its owner. The snapshot is written to a
+// temporary file first and renamed into place, so a crash never leaves a partial snapshot behind.
+func (m *MemoryAuthStore) SaveSnapshot(path string) error {
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if err := m.Snapshot(f); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Replaces the contents of the store with the snapshot in the file at path. A missing file returns an error
+// matching os.ErrNotExist, which callers starting up for the first time can ignore.
+func (m *MemoryAuthStore) LoadSnapshot(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return m.Restore(f)
+}
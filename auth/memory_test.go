@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+func TestMemoryAuthStoreDuplicates(t *testing.T) {
+	ctx := t.Context()
+	store := NewMemoryAuthStore()
+	if err := store.SaveUser(ctx, sessions.User{UserId: "01", Username: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveUser(ctx, sessions.User{UserId: "01", Username: "bob"}); !errors.Is(err, sessions.ErrUserExists) {
+		t.Errorf("saving a duplicate user id returned %v, want %v", err, sessions.ErrUserExists)
+	}
+	if err := store.SaveUser(ctx, sessions.User{UserId: "02", Username: "alice"}); !errors.Is(err, sessions.ErrUserExists) {
+		t.Errorf("saving a duplicate username returned %v, want %v", err, sessions.ErrUserExists)
+	}
+	if err := store.UpdateUser(ctx, sessions.User{UserId: "03", Username: "carol"}); !errors.Is(err, sessions.ErrUserNotFound) {
+		t.Errorf("updating a missing user returned %v, want %v", err, sessions.ErrUserNotFound)
+	}
+
+	if err := store.UpdateUser(ctx, sessions.User{UserId: "01", Username: "alicia"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadUserByUsername(ctx, "alice"); !errors.Is(err, sessions.ErrUserNotFound) {
+		t.Errorf("loading a renamed user by their old username returned %v, want %v", err, sessions.ErrUserNotFound)
+	}
+	if err := store.SaveUser(ctx, sessions.User{UserId: "02", Username: "alice"}); err != nil {
+		t.Errorf("saving a user with a freed-up username returned %v", err)
+	}
+
+	s := sessions.Session{Id: "s1", UserId: "01", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.SaveSession(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveSession(ctx, s); !errors.Is(err, sessions.ErrSessionExists) {
+		t.Errorf("saving a duplicate session returned %v, want %v", err, sessions.ErrSessionExists)
+	}
+	if err := store.DeleteSessionById(ctx, "s2"); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("deleting a missing session returned %v, want %v", err, sessions.ErrSessionNotFound)
+	}
+}
+
+func TestMemoryAuthStoreEviction(t *testing.T) {
+	ctx := t.Context()
+	now := time.Now()
+	store := NewMemoryAuthStore(WithEvictionTTL(time.Minute))
+	store.SaveSession(ctx, sessions.Session{Id: "stale", UserId: "01", ExpiresAt: now.Add(-2 * time.Minute)})
+	store.SaveSession(ctx, sessions.Session{Id: "grace", UserId: "01", ExpiresAt: now.Add(-30 * time.Second)})
+	store.SaveLoginAttempts(ctx, sessions.LoginAttempts{Key: "u:alice", Failures: 3, UpdatedAt: now.Add(-2 * time.Minute)})
+
+	if _, err := store.LoadSessionById(ctx, "stale"); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("loading an evicted session returned %v, want %v", err, sessions.ErrSessionNotFound)
+	}
+	if _, err := store.LoadSessionById(ctx, "grace"); err != nil {
+		t.Errorf("loading a session expired for less than the ttl returned %v", err)
+	}
+	if a, _ := store.LoadLoginAttempts(ctx, "u:alice"); a.Failures != 0 {
+		t.Errorf("evicted login attempts have %d failures, want 0", a.Failures)
+	}
+	// a lockout outlasting the ttl is kept until it ends
+	store.SaveLoginAttempts(ctx, sessions.LoginAttempts{Key: "u:bob", Failures: 5, UpdatedAt: now.Add(-2 * time.Minute), LockedUntil: now.Add(time.Hour)})
+	if a, _ := store.LoadLoginAttempts(ctx, "u:bob"); !a.LockedUntil.Equal(now.Add(time.Hour)) {
+		t.Errorf("a locked out key was evicted after the ttl, loaded %+v", a)
+	}
+
+	// without eviction, expired sessions stay until they are deleted
+	store = NewMemoryAuthStore()
+	store.SaveSession(ctx, sessions.Session{Id: "stale", UserId: "01", ExpiresAt: now.Add(-2 * time.Minute)})
+	if _, err := store.LoadSessionById(ctx, "stale"); err != nil {
+		t.Errorf("loading an expired session without eviction returned %v", err)
+	}
+}
+
+func TestMemoryAuthStoreSnapshot(t *testing.T) {
+	ctx := t.Context()
+	store := NewMemoryAuthStore()
+	store.SaveUser(ctx, sessions.User{UserId: "01", Username: "alice", HashedPassword: "hash"})
+	expires := time.Now().Add(time.Hour).Truncate(time.Second)
+	store.SaveSession(ctx, sessions.Session{Id: "s1", UserId: "01", ExpiresAt: expires, UserAgent: "curl"})
+	store.SaveLoginAttempts(ctx, sessions.LoginAttempts{Key: "ip:127.0.0.1", Failures: 2})
+
+	path := filepath.Join(t.TempDir(), "auth.json")
+	if err := store.SaveSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewMemoryAuthStore()
+	if err := restored.LoadSnapshot(path); err != nil {
+		t.Fatal(err)
+	}
+	u, err := restored.LoadUserByUsername(ctx, "alice")
+	if err != nil || u.HashedPassword != "hash" {
+		t.Errorf("restored user = %+v, %v", u, err)
+	}
+	s, err := restored.LoadSessionById(ctx, "s1")
+	if err != nil || !s.ExpiresAt.Equal(expires) || s.UserAgent != "curl" {
+		t.Errorf("restored session = %+v, %v", s, err)
+	}
+	if a, _ := restored.LoadLoginAttempts(ctx, "ip:127.0.0.1"); a.Failures != 2 {
+		t.Errorf("restored login attempts have %d failures, want 2", a.Failures)
+	}
+
+	if err := restored.LoadSnapshot(filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("loading a missing snapshot returned %v, want %v", err, os.ErrNotExist)
+	}
+}
@@ -0,0 +1,231 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+// the key of the Postgres advisory lock held while migrating, so that instances starting together don't race
+const postgresMigrationLockKey = 7291460378021564
+
+// A numbered change to the schema of one of the SQL stores. Migrations are applied in order of Version and each
+// applied version is recorded in the schema_migrations table.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// Returns the migrations for the SQLite store, for applying them out-of-band together with WithoutAutoMigrate. Pass
+// the same table options as to NewSQLiteStore to get the SQL for those table names.
+func SQLiteMigrations(opts ...StoreOption) ([]Migration, error) {
+	cfg, err := newStoreConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.schema != "" {
+		return nil, ErrSchemaNotSupported
+	}
+	return loadMigrations("sqlite", newSQLNames(cfg))
+}
+
+// Returns the migrations for the Postgres store, for applying them out-of-band together with WithoutAutoMigrate.
+// Pass the same table and schema options as to NewPostgresAuthStore to get the SQL for those names.
+func PostgresMigrations(opts ...StoreOption) ([]Migration, error) {
+	cfg, err := newStoreConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return loadMigrations("postgres", newSQLNames(cfg))
+}
+
+// reads the embedded migrations for a dialect, which are named like 0001_name.sql, and fills in the table names
+func loadMigrations(dialect string, names *sqlNames) ([]Migration, error) {
+	dir := path.Join("migrations", dialect)
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var migrations []Migration
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".sql")
+		if !ok {
+			continue
+		}
+		number, label, _ := strings.Cut(name, "_")
+		version, err := strconv.Atoi(number)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s does not start with a version number", entry.Name())
+		}
+		contents, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: label, SQL: names.expand(string(contents))})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Applies any migrations of the SQLite store that have not been applied to the database yet. They run in a single
+// immediate transaction, so concurrent callers wait for each other and a failed migration leaves the schema as it
+// was. Sessions whose ids are still stored in plaintext are hashed in the same transaction. Pass the same table
+// options as to NewSQLiteStore.
+func MigrateSQLite(ctx context.Context, db *sql.DB, opts ...StoreOption) error {
+	cfg, err := newStoreConfig(opts)
+	if err != nil {
+		return err
+	}
+	if cfg.schema != "" {
+		return ErrSchemaNotSupported
+	}
+	names := newSQLNames(cfg)
+	migrations, err := loadMigrations("sqlite", names)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// database/sql can't start an immediate transaction, which takes the write lock up front, so do it by hand on a
+	// dedicated connection
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return err
+	}
+	err = applyMigrations(ctx, conn, migrations, names, sqliteRecordMigrationQuery)
+	if err == nil {
+		// SQLite can't hash in SQL, so the migration that started hashing session ids left them to be hashed here
+		_, err = hashSQLiteLegacySessionIds(ctx, conn, names)
+	}
+	if err != nil {
+		conn.ExecContext(context.Background(), "ROLLBACK")
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "COMMIT")
+	return err
+}
+
+// Applies any migrations of the Postgres store that have not been applied to the database yet. An advisory lock is
+// held while migrating so that concurrent callers wait for each other, and each migration runs in its own
+// transaction. Pass the same table and schema options as to NewPostgresAuthStore.
+func MigratePostgres(ctx context.Context, db *sql.DB, opts ...StoreOption) error {
+	cfg, err := newStoreConfig(opts)
+	if err != nil {
+		return err
+	}
+	names := newSQLNames(cfg)
+	migrations, err := loadMigrations("postgres", names)
+	if err != nil {
+		return err
+	}
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", postgresMigrationLockKey); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", postgresMigrationLockKey)
+
+	if cfg.schema != "" {
+		if _, err := conn.ExecContext(ctx, `CREATE SCHEMA IF NOT EXISTS "`+cfg.schema+`"`); err != nil {
+			return err
+		}
+	}
+
+	for i := range migrations {
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			return err
+		}
+		err = applyMigrations(ctx, tx, migrations[i:i+1], names, postgresRecordMigrationQuery)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// the parts of *sql.Conn and *sql.Tx that migrations are applied with
+type execQueryer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+}
+
+// the statements recording an applied migration in each dialect
+const (
+	sqliteRecordMigrationQuery   = `INSERT INTO {{schema_migrations}} (version, name, applied_at) VALUES (?, ?, ?)`
+	postgresRecordMigrationQuery = `INSERT INTO {{schema_migrations}} (version, name, applied_at) VALUES ($1, $2, $3)`
+)
+
+// Creates the schema_migrations table if needed and applies the given migrations that it does not list yet,
+// recording each one with recordQuery.
+func applyMigrations(ctx context.Context, db execQueryer, migrations []Migration, names *sqlNames, recordQuery string) error {
+	createQuery := `
+	CREATE TABLE IF NOT EXISTS {{schema_migrations}} (
+	version INTEGER PRIMARY KEY,
+	name TEXT NOT NULL,
+	applied_at BIGINT NOT NULL -- Unix timestamp (seconds)
+	);
+	`
+	if _, err := db.ExecContext(ctx, names.expand(createQuery)); err != nil {
+		return err
+	}
+
+	applied, err := appliedMigrations(ctx, db, names)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		if applied[m.Version] {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, m.SQL); err != nil {
+			return fmt.Errorf("applying migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if _, err := db.ExecContext(ctx, names.expand(recordQuery), m.Version, m.Name, time.Now().Unix()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// returns the versions listed in schema_migrations
+func appliedMigrations(ctx context.Context, db execQueryer, names *sqlNames) (map[int]bool, error) {
+	rows, err := db.QueryContext(ctx, names.expand(`SELECT version FROM {{schema_migrations}}`))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]bool)
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	return applied, rows.Err()
+}
@@ -0,0 +1,161 @@
+package auth
+
+import (
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// opens a fresh in-memory SQLite database. It is limited to one connection because each connection to :memory:
+// gets its own database.
+func openSQLite(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func TestSQLiteMigrationsUpgradeOriginalSchema(t *testing.T) {
+	db := openSQLite(t)
+	// the schema and data as written by NewSQLiteStore before migrations existed
+	expiresAt := time.Now().Add(time.Hour).In(time.FixedZone("UTC+5", 5*60*60))
+	for _, stmt := range []string{
+		`CREATE TABLE sessions (id TEXT PRIMARY KEY, user_id TEXT NOT NULL, expires_at TIMESTAMP NOT NULL)`,
+		`CREATE TABLE users (user_id TEXT PRIMARY KEY, username TEXT NOT NULL, hashed_password TEXT NOT NULL)`,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec(`INSERT INTO sessions VALUES (?, ?, ?)`, "old-session", "01", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewSQLiteStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := store.LoadSessionById(t.Context(), "old-session")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.UserId != "01" || session.ExpiresAt.Unix() != expiresAt.Unix() {
+		t.Errorf("migrated session = %+v, want user 01 expiring at %s", session, expiresAt)
+	}
+
+	// the migration replaces the plaintext id by its digest, leaving nothing for HashLegacySessionIds to do
+	var storedId string
+	db.QueryRow(`SELECT id FROM sessions`).Scan(&storedId)
+	if storedId != sessions.SessionId("old-session").Digest() {
+		t.Errorf("after migrating, the session is stored as %q, want its digest", storedId)
+	}
+	if n, err := store.HashLegacySessionIds(t.Context()); err != nil || n != 0 {
+		t.Errorf("HashLegacySessionIds() = %d, %v, want 0", n, err)
+	}
+
+	// a second store on the same database finds nothing left to apply
+	if _, err := NewSQLiteStore(db); err != nil {
+		t.Fatalf("migrating an up to date database: %s", err)
+	}
+	migrations, _ := SQLiteMigrations()
+	var applied int
+	db.QueryRow(`SELECT count(*) FROM schema_migrations`).Scan(&applied)
+	if applied != len(migrations) {
+		t.Errorf("schema_migrations lists %d migrations, want %d", applied, len(migrations))
+	}
+
+	store.SaveUser(t.Context(), sessions.User{UserId: "01", Username: "alice", HashedPassword: "x"})
+	if err := store.SaveUser(t.Context(), sessions.User{UserId: "02", Username: "alice", HashedPassword: "y"}); err == nil {
+		t.Error("saved a second user with the same username")
+	}
+}
+
+func TestWithoutAutoMigrate(t *testing.T) {
+	db := openSQLite(t)
+	if _, err := NewSQLiteStore(db, WithoutAutoMigrate()); err != nil {
+		t.Fatal(err)
+	}
+	var tables int
+	db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table'`).Scan(&tables)
+	if tables != 0 {
+		t.Errorf("found %d tables after creating a store without auto-migration, want 0", tables)
+	}
+}
+
+func TestSQLiteTablePrefix(t *testing.T) {
+	db := openSQLite(t)
+	// the application's own users table, which the store must leave alone
+	if _, err := db.Exec(`CREATE TABLE users (id INTEGER PRIMARY KEY, email TEXT NOT NULL)`); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewSQLiteStore(db, WithTablePrefix("auth_"), WithTableNames(TableNames{Sessions: "logins"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveUser(t.Context(), sessions.User{UserId: "01", Username: "alice", HashedPassword: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveSession(t.Context(), sessions.Session{Id: "s1", UserId: "01", ExpiresAt: time.Now().Add(time.Hour)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadUserByUsername(t.Context(), "alice"); err != nil {
+		t.Error(err)
+	}
+	for _, table := range []string{"auth_users", "auth_logins", "auth_login_attempts", "auth_schema_migrations"} {
+		var n int
+		if err := db.QueryRow(`SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&n); err != nil || n != 1 {
+			t.Errorf("table %s was not created", table)
+		}
+	}
+	var appUsers int
+	db.QueryRow(`SELECT count(*) FROM users`).Scan(&appUsers)
+	if appUsers != 0 {
+		t.Errorf("the application's users table has %d rows, want 0", appUsers)
+	}
+
+	if _, err := NewSQLiteStore(db, WithTablePrefix(`x"; DROP TABLE users; --`)); err != ErrInvalidTableName {
+		t.Errorf("creating a store with an unsafe prefix returned %v, want %v", err, ErrInvalidTableName)
+	}
+	if _, err := NewSQLiteStore(db, WithSchema("auth")); err != ErrSchemaNotSupported {
+		t.Errorf("creating a SQLite store with a schema returned %v, want %v", err, ErrSchemaNotSupported)
+	}
+}
+
+func TestSQLiteStoresSessionDigests(t *testing.T) {
+	db := openSQLite(t)
+	store, err := NewSQLiteStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := t.Context()
+	session := sessions.Session{Id: "secret-session-id", UserId: "01", ExpiresAt: time.Now().Add(time.Hour)}
+	if err := store.SaveSession(ctx, session); err != nil {
+		t.Fatal(err)
+	}
+
+	var storedId string
+	db.QueryRow(`SELECT id FROM sessions`).Scan(&storedId)
+	if storedId != session.Id.Digest() {
+		t.Errorf("the session is stored as %q, want the digest of its id", storedId)
+	}
+	list, err := store.ListSessionsByUserId(ctx, "01")
+	if err != nil || len(list) != 1 || !list[0].Id.IsDigest() {
+		t.Fatalf("ListSessionsByUserId() = %v, %v, want the session listed by its digest", list, err)
+	}
+	// the digest found in the database or a listing doesn't work in place of the id
+	if _, err := store.LoadSessionById(ctx, string(list[0].Id)); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("LoadSessionById() with the listed id returned %v, want %v", err, sessions.ErrSessionNotFound)
+	}
+	if _, err := store.LoadSessionById(ctx, storedId); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("LoadSessionById() with the stored digest returned %v, want %v", err, sessions.ErrSessionNotFound)
+	}
+}
@@ -0,0 +1,13 @@
+-- The tables created by NewPostgresAuthStore before migrations existed. IF NOT EXISTS lets databases created back
+-- then pick up the migrations from here.
+CREATE TABLE IF NOT EXISTS {{sessions}} (
+id TEXT PRIMARY KEY,
+user_id TEXT NOT NULL,
+expires_at BIGINT NOT NULL -- Unix timestamp (seconds)
+);
+
+CREATE TABLE IF NOT EXISTS {{users}} (
+user_id TEXT PRIMARY KEY NOT NULL,
+username TEXT NOT NULL UNIQUE,
+hashed_password TEXT NOT NULL
+);
@@ -0,0 +1,16 @@
+-- Adds the creation time used for absolute session lifetimes, the metadata shown in session listings, and indexes
+-- on the columns sessions are looked up and reaped by. Sessions from before this migration get the time of the
+-- migration as their creation time.
+ALTER TABLE {{sessions}}
+ADD COLUMN created_at BIGINT NOT NULL DEFAULT (EXTRACT(EPOCH FROM now())::BIGINT), -- Unix timestamp (seconds)
+ADD COLUMN last_seen_at BIGINT NOT NULL DEFAULT (EXTRACT(EPOCH FROM now())::BIGINT), -- Unix timestamp (seconds)
+ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+ADD COLUMN ip_address TEXT NOT NULL DEFAULT '';
+
+ALTER TABLE {{sessions}}
+ALTER COLUMN created_at DROP DEFAULT,
+ALTER COLUMN last_seen_at SET DEFAULT 0;
+
+CREATE INDEX {{sessions_user_id_idx}} ON {{sessions}} (user_id);
+
+CREATE INDEX {{sessions_expires_at_idx}} ON {{sessions}} (expires_at);
@@ -0,0 +1,3 @@
+-- Sessions are stored under the hex SHA-256 digest of their id, so that reading the table, or a backup of it, doesn't
+-- give away live session cookies. The ids of existing sessions are hashed in place, which needs Postgres 11 or later.
+UPDATE {{sessions}} SET id = encode(sha256(convert_to(id, 'UTF8')), 'hex');
@@ -0,0 +1,25 @@
+-- Stores session timestamps as Unix seconds like the Postgres store does, adds the metadata shown in session
+-- listings, and indexes the columns sessions are looked up and reaped by. SQLite cannot change a column's type, so
+-- the table is rebuilt. Sessions from before this migration get the time of the migration as their creation time.
+CREATE TABLE {{sessions_new}} (
+id TEXT PRIMARY KEY,
+user_id TEXT NOT NULL,
+created_at INTEGER NOT NULL, -- Unix timestamp (seconds)
+expires_at INTEGER NOT NULL, -- Unix timestamp (seconds)
+last_seen_at INTEGER NOT NULL DEFAULT 0, -- Unix timestamp (seconds)
+user_agent TEXT NOT NULL DEFAULT '',
+ip_address TEXT NOT NULL DEFAULT ''
+);
+
+INSERT INTO {{sessions_new}} (id, user_id, created_at, expires_at, last_seen_at)
+SELECT id, user_id, CAST(strftime('%s', 'now') AS INTEGER), CAST(strftime('%s', expires_at) AS INTEGER),
+CAST(strftime('%s', 'now') AS INTEGER)
+FROM {{sessions}};
+
+DROP TABLE {{sessions}};
+
+ALTER TABLE {{sessions_new}} RENAME TO {{sessions}};
+
+CREATE INDEX {{sessions_user_id_idx}} ON {{sessions}} (user_id);
+
+CREATE INDEX {{sessions_expires_at_idx}} ON {{sessions}} (expires_at);
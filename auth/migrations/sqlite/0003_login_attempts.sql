@@ -0,0 +1,8 @@
+-- Login throttling state used by StoreLimiter.
+CREATE TABLE {{login_attempts}} (
+attempt_key TEXT PRIMARY KEY,
+tokens REAL NOT NULL,
+updated_at INTEGER NOT NULL, -- Unix timestamp (milliseconds)
+failures INTEGER NOT NULL,
+locked_until INTEGER NOT NULL -- Unix timestamp (milliseconds)
+);
@@ -0,0 +1,3 @@
+-- Usernames were only unique by convention in SQLite, unlike Postgres. This fails if duplicates already exist, which
+-- have to be resolved by hand first.
+CREATE UNIQUE INDEX {{users_username_idx}} ON {{users}} (username);
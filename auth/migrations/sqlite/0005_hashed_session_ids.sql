@@ -0,0 +1,5 @@
+-- Sessions are stored under the hex SHA-256 digest of their id, so that reading the table, or a backup of it, doesn't
+-- give away live session cookies. SQLite can't compute SHA-256, so the plaintext ids of existing sessions are marked
+-- with a plain: prefix instead. The store still finds them by their id, and MigrateSQLite replaces them with
+-- their digests once the migrations have run.
+UPDATE {{sessions}} SET id = 'plain:' || id;
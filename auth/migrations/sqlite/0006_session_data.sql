@@ -0,0 +1,2 @@
+-- The values kept with each session, as a JSON object.
+ALTER TABLE {{sessions}} ADD COLUMN data TEXT NOT NULL DEFAULT '{}';
@@ -0,0 +1,131 @@
+package auth
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// An Option configures optional behavior of an AuthContext when passed to NewAuthContext.
+type Option func(*AuthContext)
+
+// Sets an absolute lifetime for sessions. Sessions are renewed while the user is active, but never past this long
+// after they were created.
+func WithMaxLifetime(d time.Duration) Option {
+	return func(ac *AuthContext) {
+		ac.MaxLifetime = d
+	}
+}
+
+// Sets the fraction (between 0 and 1) of the session duration that must elapse before the middleware renews a
+// session and re-issues its cookie. A fraction of 0 disables renewal so sessions expire a fixed time after login.
+func WithRenewAfter(fraction float64) Option {
+	return func(ac *AuthContext) {
+		ac.RenewAfter = fraction
+	}
+}
+
+// Sets the name, domain, path and security attributes of the session cookie. Use cfg.Validate() to check the
+// settings before passing them in.
+func WithCookieConfig(cfg sessions.CookieConfig) Option {
+	return func(ac *AuthContext) {
+		ac.Cookie = cfg
+	}
+}
+
+// Sets the policy that decides which usernames and passwords are accepted at registration. Passing nil disables
+// validation and normalization entirely.
+func WithPolicy(p Policy) Option {
+	return func(ac *AuthContext) {
+		ac.Policy = p
+	}
+}
+
+// Sets the hasher used for new passwords, along with legacy hashers whose existing hashes are still accepted at
+// login and then upgraded to the new hasher.
+func WithPasswordHasher(h PasswordHasher, legacy ...PasswordHasher) Option {
+	return func(ac *AuthContext) {
+		ac.Hasher = h
+		ac.LegacyHashers = legacy
+	}
+}
+
+// Pins the hasher whose hash logins for unknown usernames are checked against, instead of whichever of the password
+// hashers is timed slowest when the AuthContext is created. Pick the one that most stored hashes were made by, so
+// that unknown usernames take as long as most real ones.
+func WithDummyPasswordHasher(h PasswordHasher) Option {
+	return func(ac *AuthContext) {
+		ac.dummyHasher = h
+	}
+}
+
+// Throttles login attempts with the given limiter, for example NewMemoryLimiter(DefaultLimiterConfig()) for a single
+// instance or NewStoreLimiter(store, DefaultLimiterConfig()) to share state between instances.
+func WithLoginLimiter(l LoginLimiter) Option {
+	return func(ac *AuthContext) {
+		ac.Limiter = l
+	}
+}
+
+// Sets how the client IP address used for login throttling is read from a request, for example from a header set by
+// a trusted reverse proxy.
+func WithClientIP(fn func(*http.Request) string) Option {
+	return func(ac *AuthContext) {
+		ac.ClientIP = fn
+	}
+}
+
+// Deletes expired sessions from the store every interval, at most batchSize rows per statement so that large
+// backlogs don't hold long locks. The reaper is started by NewAuthContext and stopped by AuthContext.Close.
+func WithSessionReaper(interval time.Duration, batchSize int) Option {
+	return func(ac *AuthContext) {
+		// the store is filled in by NewAuthContext, after WithSessionStore may have changed it
+		ac.Reaper = NewReaper(nil, interval, batchSize)
+	}
+}
+
+// Sets the clock used to timestamp sessions and decide when they expire or are renewed, for example a fake clock in
+// tests.
+func WithClock(clock sessions.Clock) Option {
+	return func(ac *AuthContext) {
+		ac.Clock = clock
+	}
+}
+
+// Sets the generator for new session ids, for example sessions.Random256 for 256-bit ids or a deterministic
+// generator in tests.
+func WithSessionIdGenerator(gen sessions.IdGenerator) Option {
+	return func(ac *AuthContext) {
+		ac.SessionIds = gen
+	}
+}
+
+// Sets the generator for the ids of new users, for example sessions.UUIDv7 to match ids made elsewhere.
+func WithUserIdGenerator(gen sessions.IdGenerator) Option {
+	return func(ac *AuthContext) {
+		ac.UserIds = gen
+	}
+}
+
+// Keeps users in the given store instead of the one passed to NewAuthContext.
+func WithUserStore(store sessions.UserStore) Option {
+	return func(ac *AuthContext) {
+		ac.Users = store
+	}
+}
+
+// Keeps sessions in the given store instead of the one passed to NewAuthContext, for example in Redis while users
+// stay in Postgres. NewAuthContextWithStores does the same without requiring the first store to hold sessions.
+func WithSessionStore(store sessions.SessionStore) Option {
+	return func(ac *AuthContext) {
+		ac.Sessions = store
+	}
+}
+
+// Sets the header, form field, cookie and exemptions used by CSRFMiddleware.
+func WithCSRFConfig(cfg CSRFConfig) Option {
+	return func(ac *AuthContext) {
+		ac.CSRF = cfg
+	}
+}
@@ -0,0 +1,247 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+)
+
+// Estimates how hard a password is to guess on a scale from 0 (trivial) to 4 (very strong), in the spirit of
+// zxcvbn. The estimate charges little for characters that repeat, continue a sequence ("abc", "321") or walk along a
+// keyboard row ("qwerty"), and treats common passwords or any of the userInputs (such as the username) appearing in
+// the password as a single cheap guess. Leetspeak substitutions ("p@ssw0rd") do not help.
+func EstimatePasswordStrength(password string, userInputs ...string) int {
+	if password == "" {
+		return 0
+	}
+	plain := unleet(strings.ToLower(password))
+
+	words := make([]string, 0, len(userInputs))
+	for _, input := range userInputs {
+		input = unleet(strings.ToLower(input))
+		if len([]rune(input)) >= 3 {
+			words = append(words, input)
+		}
+	}
+
+	// a common password or user input with some digits or symbols tacked on is still one of the first guesses
+	notLetter := func(r rune) bool { return !unicode.IsLetter(r) }
+	lower := strings.ToLower(password)
+	candidates := []string{lower, plain, strings.TrimRightFunc(lower, notLetter), strings.TrimRightFunc(plain, notLetter)}
+	for _, candidate := range candidates {
+		if _, ok := commonPasswords[candidate]; ok {
+			return 0
+		}
+		for _, word := range words {
+			if candidate == word {
+				return 0
+			}
+		}
+	}
+
+	runes := []rune(plain)
+	bitsPerChar := math.Log2(float64(characterPool(password)))
+	bits := 0.0
+	for i := 0; i < len(runes); {
+		// a dictionary word in the middle of the password costs about as much as guessing which word it is
+		if n := dictionaryMatch(runes[i:], words); n > 0 {
+			bits += dictionaryWordBits
+			i += n
+			continue
+		}
+		if i > 0 && isPredictableStep(runes[i-1], runes[i]) {
+			bits += 1
+		} else {
+			bits += bitsPerChar
+		}
+		i++
+	}
+
+	switch {
+	case bits < 20:
+		return 0
+	case bits < 35:
+		return 1
+	case bits < 50:
+		return 2
+	case bits < 65:
+		return 3
+	}
+	return 4
+}
+
+// roughly log2 of the number of entries in a large password dictionary
+const dictionaryWordBits = 14
+
+// returns the length of the longest common password or user input of at least four characters that the runes
+// start with, or zero if there is none
+func dictionaryMatch(runes []rune, words []string) int {
+	longest := 0
+	for n := len(runes); n >= 4 && longest == 0; n-- {
+		candidate := string(runes[:n])
+		if _, ok := commonPasswords[candidate]; ok {
+			longest = n
+		}
+		for _, word := range words {
+			if candidate == word {
+				longest = n
+			}
+		}
+	}
+	return longest
+}
+
+// returns the size of the character classes a password draws from
+func characterPool(password string) int {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range password {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r < unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+	pool := 0
+	if lower {
+		pool += 26
+	}
+	if upper {
+		pool += 26
+	}
+	if digit {
+		pool += 10
+	}
+	if symbol {
+		pool += 33
+	}
+	if other {
+		pool += 100
+	}
+	return pool
+}
+
+var keyboardRows = []string{"`1234567890-=", "qwertyuiop[]\\", "asdfghjkl;'", "zxcvbnm,./"}
+
+// reports whether next is the obvious character to type after prev: a repeat, the next or previous character in
+// sequence, or a neighboring key on the same keyboard row
+func isPredictableStep(prev, next rune) bool {
+	if next == prev || next == prev+1 || next == prev-1 {
+		return true
+	}
+	for _, row := range keyboardRows {
+		i := strings.IndexRune(row, prev)
+		if i < 0 {
+			continue
+		}
+		if (i+1 < len(row) && rune(row[i+1]) == next) || (i > 0 && rune(row[i-1]) == next) {
+			return true
+		}
+	}
+	return false
+}
+
+var leetReplacer = strings.NewReplacer("@", "a", "4", "a", "8", "b", "3", "e", "1", "i", "!", "i", "0", "o", "$", "s", "5", "s", "7", "t", "+", "t")
+
+func unleet(s string) string {
+	// keep trailing digits as digits so that "password123" is seen as "password" followed by a number
+	trimmed := strings.TrimRightFunc(s, unicode.IsDigit)
+	return leetReplacer.Replace(trimmed) + s[len(trimmed):]
+}
+
+var commonPasswords = func() map[string]struct{} {
+	words := strings.Fields(`
+		password passw0rd letmein welcome admin administrator login qwerty qwertyuiop asdfgh asdfghjkl zxcvbnm
+		iloveyou monkey dragon master shadow sunshine princess football baseball basketball soccer hockey superman
+		batman trustno1 whatever freedom secret abc123 access hello charlie donald michael jennifer jordan hunter
+		ranger buster thomas robert daniel george harley ginger pepper cookie summer winter spring autumn flower
+		starwars pokemon computer internet samsung google apple orange banana chocolate cheese killer ninja mustang
+		liverpool chelsea arsenal maggie jessica ashley bailey passpass changeme default guest test testing user
+		username root toor love lovely angel angels family friends forever blink182 matrix silver golden diamond
+		purple yellow loveme whatever1 zaq1zaq1 qazwsx 1qaz2wsx 123qwe qwe123 letmein1 mypassword mustang1 starwars1
+		`)
+	m := make(map[string]struct{}, len(words))
+	for _, w := range words {
+		m[w] = struct{}{}
+	}
+	return m
+}()
+
+// A set of passwords known to have appeared in breaches, loaded from a local file so that registration never has
+// to call out to a third-party service.
+type BreachedPasswords struct {
+	hashes map[[sha1.Size]byte]struct{}
+}
+
+// Loads a breached password list from a file with one entry per line. Each line can be a plaintext password, a hex
+// SHA-1 digest of one, or a line from the Have I Been Pwned downloads in the form "SHA1:COUNT". Blank lines and
+// lines starting with '#' are ignored. The whole list is held in memory, so use a curated subset such as the most
+// common few million entries rather than a full breach corpus.
+func LoadBreachedPasswords(path string) (*BreachedPasswords, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	b := &BreachedPasswords{hashes: make(map[[sha1.Size]byte]struct{})}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		b.hashes[breachEntryHash(line)] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// returns the SHA-1 digest for a line of a breached password file
+func breachEntryHash(line string) [sha1.Size]byte {
+	candidate, _, _ := strings.Cut(line, ":")
+	var digest [sha1.Size]byte
+	if len(candidate) == hex.EncodedLen(sha1.Size) {
+		if _, err := hex.Decode(digest[:], []byte(candidate)); err == nil {
+			return digest
+		}
+	}
+	return sha1.Sum([]byte(line))
+}
+
+// Returns the number of entries in the list.
+func (b *BreachedPasswords) Len() int {
+	return len(b.hashes)
+}
+
+// Reports whether the password is in the list.
+func (b *BreachedPasswords) Contains(password string) bool {
+	_, ok := b.hashes[sha1.Sum([]byte(password))]
+	return ok
+}
+
+// Returns a PasswordRule that rejects any password in the list.
+func (b *BreachedPasswords) Rule() PasswordRule {
+	return func(password string, _ string) *FieldError {
+		if b.Contains(password) {
+			return &FieldError{
+				Field:   "password",
+				Code:    FieldCodeBreached,
+				Message: "Password has appeared in a data breach",
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,197 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Machine-readable codes used in FieldError.Code by the built-in rules.
+const (
+	FieldCodeTooShort    = "too_short"
+	FieldCodeTooLong     = "too_long"
+	FieldCodeInvalidChar = "invalid_character"
+	FieldCodeReserved    = "reserved"
+	FieldCodeTooWeak     = "too_weak"
+	FieldCodeBreached    = "breached"
+)
+
+// A problem with a single field of a request, returned in the "fields" list of an error response.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// A Policy decides which usernames and passwords are acceptable when a user registers.
+type Policy interface {
+	// Returns the canonical form of a username, which is what gets stored and looked up at login.
+	NormalizeUsername(username string) string
+	// Returns the rules a normalized username breaks, or nothing if it is acceptable.
+	ValidateUsername(username string) []FieldError
+	// Returns the rules a password breaks, or nothing if it is acceptable. The normalized username is passed so
+	// that passwords based on it can be rejected.
+	ValidatePassword(password string, username string) []FieldError
+}
+
+// Checks a normalized username, returning nil if it passes.
+type UsernameRule func(username string) *FieldError
+
+// Checks a password for the given normalized username, returning nil if it passes.
+type PasswordRule func(password string, username string) *FieldError
+
+// A Policy built from a normalization function and lists of rules, all of which are checked so that every problem
+// can be reported at once.
+type RulePolicy struct {
+	// Normalizes usernames before they are validated, stored or looked up. Nil leaves usernames unchanged.
+	Normalize     func(string) string
+	UsernameRules []UsernameRule
+	PasswordRules []PasswordRule
+}
+
+// Returns the policy used when none is configured: usernames are case-folded, 3 to 254 letters, digits, '_', '-',
+// '.', '@' or '+', so that email addresses can be used, and not reserved; passwords are 8 to 256 characters with a
+// strength score of at least 2.
+func DefaultPolicy() *RulePolicy {
+	return &RulePolicy{
+		Normalize: FoldUsername,
+		UsernameRules: []UsernameRule{
+			UsernameLength(3, 254),
+			UsernameCharset(isDefaultUsernameRune, "letters, digits, '_', '-', '.', '@' and '+'"),
+			ReservedUsernames(defaultReservedUsernames...),
+		},
+		PasswordRules: []PasswordRule{
+			PasswordLength(8, 256),
+			PasswordStrength(2),
+		},
+	}
+}
+
+func (p *RulePolicy) NormalizeUsername(username string) string {
+	if p.Normalize == nil {
+		return username
+	}
+	return p.Normalize(username)
+}
+
+func (p *RulePolicy) ValidateUsername(username string) []FieldError {
+	var errs []FieldError
+	for _, rule := range p.UsernameRules {
+		if fieldErr := rule(username); fieldErr != nil {
+			errs = append(errs, *fieldErr)
+		}
+	}
+	return errs
+}
+
+func (p *RulePolicy) ValidatePassword(password string, username string) []FieldError {
+	var errs []FieldError
+	for _, rule := range p.PasswordRules {
+		if fieldErr := rule(password, username); fieldErr != nil {
+			errs = append(errs, *fieldErr)
+		}
+	}
+	return errs
+}
+
+var foldCase = cases.Fold()
+
+// Normalizes a username with Unicode NFKC, case folding and whitespace trimming, so that visually identical
+// usernames like "Alice", "alice" and "ａｌｉｃｅ" all refer to the same account.
+func FoldUsername(username string) string {
+	return norm.NFKC.String(foldCase.String(norm.NFKC.String(strings.TrimSpace(username))))
+}
+
+// Rejects usernames shorter than min or longer than max characters. A max of zero means no upper limit.
+func UsernameLength(min, max int) UsernameRule {
+	return func(username string) *FieldError {
+		return checkLength("username", utf8.RuneCountInString(username), min, max)
+	}
+}
+
+// Rejects usernames containing any character for which allowed returns false. The description of the allowed
+// characters is used in the error message.
+func UsernameCharset(allowed func(rune) bool, description string) UsernameRule {
+	return func(username string) *FieldError {
+		for _, r := range username {
+			if !allowed(r) {
+				return &FieldError{
+					Field:   "username",
+					Code:    FieldCodeInvalidChar,
+					Message: fmt.Sprintf("Username can only contain %s", description),
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// Rejects usernames that match any of the given names after case folding.
+func ReservedUsernames(names ...string) UsernameRule {
+	reserved := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		reserved[FoldUsername(name)] = struct{}{}
+	}
+	return func(username string) *FieldError {
+		if _, ok := reserved[FoldUsername(username)]; ok {
+			return &FieldError{
+				Field:   "username",
+				Code:    FieldCodeReserved,
+				Message: "That username is reserved",
+			}
+		}
+		return nil
+	}
+}
+
+// Rejects passwords shorter than min or longer than max characters. A max of zero means no upper limit.
+func PasswordLength(min, max int) PasswordRule {
+	return func(password string, _ string) *FieldError {
+		return checkLength("password", utf8.RuneCountInString(password), min, max)
+	}
+}
+
+// Rejects passwords whose EstimatePasswordStrength score is below minScore.
+func PasswordStrength(minScore int) PasswordRule {
+	return func(password string, username string) *FieldError {
+		if EstimatePasswordStrength(password, username) < minScore {
+			return &FieldError{
+				Field:   "password",
+				Code:    FieldCodeTooWeak,
+				Message: "Password is too easy to guess",
+			}
+		}
+		return nil
+	}
+}
+
+func checkLength(field string, length, min, max int) *FieldError {
+	name := strings.ToUpper(field[:1]) + field[1:]
+	if length < min {
+		return &FieldError{
+			Field:   field,
+			Code:    FieldCodeTooShort,
+			Message: fmt.Sprintf("%s must be at least %d characters", name, min),
+		}
+	}
+	if max > 0 && length > max {
+		return &FieldError{
+			Field:   field,
+			Code:    FieldCodeTooLong,
+			Message: fmt.Sprintf("%s must be at most %d characters", name, max),
+		}
+	}
+	return nil
+}
+
+func isDefaultUsernameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("_-.@+", r)
+}
+
+var defaultReservedUsernames = []string{
+	"admin", "administrator", "root", "system", "support", "help", "security", "api", "null", "undefined",
+}
@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEstimatePasswordStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		inputs   []string
+		maxScore int
+		minScore int
+	}{
+		{password: "password", maxScore: 0},
+		{password: "P@ssw0rd123", maxScore: 0},
+		{password: "abcdefghij", maxScore: 0},
+		{password: "qwertyuiop1", maxScore: 1},
+		{password: "alice2024!", inputs: []string{"alice"}, maxScore: 1},
+		{password: "correct horse battery staple", minScore: 4, maxScore: 4},
+		{password: "v7#Qm!2zLp", minScore: 3, maxScore: 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.password, func(t *testing.T) {
+			got := EstimatePasswordStrength(tt.password, tt.inputs...)
+			if got < tt.minScore || got > tt.maxScore {
+				t.Errorf("EstimatePasswordStrength() = %d, want between %d and %d", got, tt.minScore, tt.maxScore)
+			}
+		})
+	}
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	p := DefaultPolicy()
+
+	if got := p.NormalizeUsername("  ＡＬＩＣＥ "); got != "alice" {
+		t.Errorf("NormalizeUsername() = %q, want %q", got, "alice")
+	}
+
+	usernames := map[string]string{
+		"al":                     FieldCodeTooShort,
+		"al ice":                 FieldCodeInvalidChar,
+		"Admin":                  FieldCodeReserved,
+		"alice_99":               "",
+		"Alice+News@Example.com": "",
+	}
+	for username, wantCode := range usernames {
+		errs := p.ValidateUsername(p.NormalizeUsername(username))
+		if wantCode == "" && len(errs) != 0 {
+			t.Errorf("ValidateUsername(%q) = %v, want no errors", username, errs)
+		}
+		if wantCode != "" && (len(errs) != 1 || errs[0].Code != wantCode) {
+			t.Errorf("ValidateUsername(%q) = %v, want a single %s error", username, errs, wantCode)
+		}
+	}
+
+	if errs := p.ValidatePassword("short", "alice"); len(errs) != 2 {
+		t.Errorf("ValidatePassword() = %v, want too_short and too_weak errors", errs)
+	}
+}
+
+func TestBreachedPasswords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breached.txt")
+	// "hunter2" in plaintext and "letmein" as an HIBP-style SHA-1 line
+	content := "# comment\nhunter2\nB7A875FC1EA228B9061041B7CEC4BD3C52AB3CE3:1234\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := LoadBreachedPasswords(path)
+	if err != nil {
+		t.Fatalf("LoadBreachedPasswords() error = %v", err)
+	}
+	for _, password := range []string{"hunter2", "letmein"} {
+		if !b.Contains(password) {
+			t.Errorf("Contains(%q) = false, want true", password)
+		}
+	}
+	if b.Contains("not breached") {
+		t.Error("Contains() = true for a password that is not in the list")
+	}
+}
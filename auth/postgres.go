@@ -11,67 +11,51 @@ import (
 
 type PostgresAuthStore struct {
 	DB *sql.DB
-}
 
-// Returns a new SQLite AuthStore and creates the necessary user and sessions tables if they don't exist
-func NewPostgresAuthStore(db *sql.DB) (*PostgresAuthStore, error) {
+	names *sqlNames
+}
 
-	// set up session table
-	newSessionTableQuery := `
-	CREATE TABLE IF NOT EXISTS sessions (
-	id TEXT PRIMARY KEY,
-	user_id TEXT NOT NULL,
-	expires_at BIGINT NOT NULL -- Unix timestamp (seconds)
-	);
-	`
-	_, err := db.Exec(newSessionTableQuery)
+// Returns a new Postgres AuthStore and brings the database schema up to date by applying any migrations that have
+// not been applied yet, unless WithoutAutoMigrate is given.
+func NewPostgresAuthStore(db *sql.DB, opts ...StoreOption) (*PostgresAuthStore, error) {
+	cfg, err := newStoreConfig(opts)
 	if err != nil {
 		return nil, err
 	}
-
-	// set up session table
-	newUserTableQuery := `
-	CREATE TABLE IF NOT EXISTS users (
-	user_id TEXT PRIMARY KEY NOT NULL,
-	username TEXT NOT NULL UNIQUE,
-	hashed_password TEXT NOT NULL
-	);
-	`
-	_, err = db.Exec(newUserTableQuery)
-	if err != nil {
-		return nil, err
+	if cfg.autoMigrate {
+		if err := MigratePostgres(context.Background(), db, opts...); err != nil {
+			return nil, err
+		}
 	}
-
 	return &PostgresAuthStore{
-		DB: db,
+		DB:    db,
+		names: newSQLNames(cfg),
 	}, nil
 }
 
+// fills in the configured table names of a query
+func (pg *PostgresAuthStore) query(q string) string {
+	return pg.names.expand(q)
+}
+
 // save a user with the Postgres store
-func (pg *PostgresAuthStore) SaveUser(u sessions.User) error {
-	existingUser, err := pg.LoadUserByUserId(u.UserId, context.Background())
-	if !errors.Is(err, sessions.ErrUserNotFound) {
-		return err
-	}
-	if existingUser.HashedPassword != "" {
-		return errors.New("User already exists, cannot save user")
-	}
+func (pg *PostgresAuthStore) SaveUser(ctx context.Context, u sessions.User) error {
 	newUserQuery := `
-		INSERT INTO users (user_id, hashed_password, username)
+		INSERT INTO {{users}} (user_id, hashed_password, username)
 		VALUES ($1, $2, $3)
 		`
-	_, err = pg.DB.Exec(newUserQuery, u.UserId, u.HashedPassword, u.Username)
-	if err != nil {
-		return err
+	_, err := pg.DB.ExecContext(ctx, pg.query(newUserQuery), u.UserId, u.HashedPassword, u.Username)
+	if isUniqueViolation(err) {
+		return sessions.ErrUserExists
 	}
-	return nil
+	return err
 }
 
 // Load user in Postgres store
-func (pg *PostgresAuthStore) LoadUserByUserId(id string, ctx context.Context) (sessions.User, error) {
+func (pg *PostgresAuthStore) LoadUserByUserId(ctx context.Context, id string) (sessions.User, error) {
 	var u sessions.User
 	u.UserId = id
-	err := pg.DB.QueryRowContext(ctx, "SELECT hashed_password, username FROM users WHERE user_id = $1", id).Scan(&u.HashedPassword, &u.Username)
+	err := pg.DB.QueryRowContext(ctx, pg.query("SELECT hashed_password, username FROM {{users}} WHERE user_id = $1"), id).Scan(&u.HashedPassword, &u.Username)
 	if errors.Is(sql.ErrNoRows, err) {
 		return u, sessions.ErrUserNotFound
 	} else if err != nil {
@@ -81,10 +65,10 @@ func (pg *PostgresAuthStore) LoadUserByUserId(id string, ctx context.Context) (s
 }
 
 // Load user in Postgres store
-func (pg *PostgresAuthStore) LoadUserByUsername(username string, ctx context.Context) (sessions.User, error) {
+func (pg *PostgresAuthStore) LoadUserByUsername(ctx context.Context, username string) (sessions.User, error) {
 	var u sessions.User
 	u.Username = username
-	err := pg.DB.QueryRowContext(ctx, "SELECT hashed_password, user_id FROM users WHERE username = $1", username).Scan(&u.HashedPassword, &u.UserId)
+	err := pg.DB.QueryRowContext(ctx, pg.query("SELECT hashed_password, user_id FROM {{users}} WHERE username = $1"), username).Scan(&u.HashedPassword, &u.UserId)
 	if errors.Is(sql.ErrNoRows, err) {
 		return u, sessions.ErrUserNotFound
 	} else if err != nil {
@@ -93,27 +77,110 @@ func (pg *PostgresAuthStore) LoadUserByUsername(username string, ctx context.Con
 	return u, nil
 }
 
-// Save session in Postgres store
-func (pg *PostgresAuthStore) SaveSession(session sessions.Session) error {
-	newSessionQuery := `
-		INSERT INTO sessions (id, user_id, expires_at)
-		VALUES ($1, $2, $3)
-		`
-	_, err := pg.DB.Exec(newSessionQuery, session.Id, session.UserId, session.ExpiresAt.Unix())
+// Update user in Postgres store
+func (pg *PostgresAuthStore) UpdateUser(ctx context.Context, u sessions.User) error {
+	updateUserQuery := `
+	UPDATE {{users}}
+	SET username = $2, hashed_password = $3
+	WHERE user_id = $1
+	`
+	result, err := pg.DB.ExecContext(ctx, pg.query(updateUserQuery), u.UserId, u.Username, u.HashedPassword)
+	if isUniqueViolation(err) {
+		return sessions.ErrUserExists
+	} else if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+
+	if affected == 0 {
+		return sessions.ErrUserNotFound
+	}
 	return nil
 }
 
-// Delete session in Postgres store
-func (pg *PostgresAuthStore) DeleteSessionById(id string) error {
+// Deletes a user and all of their sessions in a single transaction
+func (pg *PostgresAuthStore) DeleteUserByUserId(ctx context.Context, id string) error {
+	tx, err := pg.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, pg.query(`DELETE FROM {{sessions}} WHERE user_id = $1`), id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, pg.query(`DELETE FROM {{users}} WHERE user_id = $1`), id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sessions.ErrUserNotFound
+	}
+	return tx.Commit()
+}
+
+// Save session in Postgres store under the digest of its id
+func (pg *PostgresAuthStore) SaveSession(ctx context.Context, session sessions.Session) error {
+	return pg.saveSession(ctx, pg.DB, session)
+}
+
+// Deletes the session with the old id, if it still exists, and saves the new session in a single transaction
+func (pg *PostgresAuthStore) ReplaceSession(ctx context.Context, oldSessionId string, session sessions.Session) error {
+	tx, err := pg.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, pg.query(`DELETE FROM {{sessions}} WHERE id = $1`), sessionDigest(oldSessionId))
+	if err != nil {
+		return err
+	}
+	if err := pg.saveSession(ctx, tx, session); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// inserts a session with the given connection or transaction
+func (pg *PostgresAuthStore) saveSession(ctx context.Context, db execQueryer, session sessions.Session) error {
+	data, err := session.Data.Encode()
+	if err != nil {
+		return err
+	}
+	// the data is sent as text and cast, which every driver encodes the same way
+	newSessionQuery := `
+		INSERT INTO {{sessions}} (id, user_id, created_at, expires_at, last_seen_at, user_agent, ip_address, data)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8::text::jsonb)
+		`
+	_, err = db.ExecContext(ctx, pg.query(newSessionQuery), sessionDigest(string(session.Id)), session.UserId, session.CreatedAt.Unix(), session.ExpiresAt.Unix(),
+		session.LastSeenAt.Unix(), session.UserAgent, session.IPAddress, data)
+	if isUniqueViolation(err) {
+		return sessions.ErrSessionExists
+	}
+	return err
+}
+
+// Delete session in Postgres store by its id, or by the id it was listed under
+func (pg *PostgresAuthStore) DeleteSessionById(ctx context.Context, id string) error {
 
 	deleteSessionQuery := `
-	DELETE FROM sessions
+	DELETE FROM {{sessions}}
 	WHERE id = $1
 	`
-	result, err := pg.DB.Exec(deleteSessionQuery, id)
+	result, err := pg.DB.ExecContext(ctx, pg.query(deleteSessionQuery), sessionDigest(id))
 	if err != nil {
 		return err
 	}
@@ -124,24 +191,212 @@ func (pg *PostgresAuthStore) DeleteSessionById(id string) error {
 	}
 
 	if affected == 0 {
-		return errors.New("Session not found")
+		return sessions.ErrSessionNotFound
 	}
 	return nil
 }
 
-// Load session in Postgres store
-func (pg *PostgresAuthStore) LoadSessionById(id string, ctx context.Context) (sessions.Session, error) {
+// Load session in Postgres store, looking it up by the digest of its id
+func (pg *PostgresAuthStore) LoadSessionById(ctx context.Context, id string) (sessions.Session, error) {
 	var session sessions.Session
 	session.Id = sessions.SessionId(id)
-	var storedUserID string
+	if session.Id.IsDigest() {
+		return session, sessions.ErrSessionNotFound
+	}
+	var storedUserID, data string
 	// var expiresAt time.Time
-	var expiresAtUnix int64
-	query := `SELECT user_id, expires_at FROM sessions WHERE id = $1`
-	err := pg.DB.QueryRowContext(ctx, query, id).Scan(&storedUserID, &expiresAtUnix)
+	var createdAtUnix, expiresAtUnix, lastSeenAtUnix int64
+	query := `SELECT user_id, created_at, expires_at, last_seen_at, user_agent, ip_address, data::text FROM {{sessions}} WHERE id = $1`
+	err := pg.DB.QueryRowContext(ctx, pg.query(query), sessionDigest(id)).Scan(&storedUserID, &createdAtUnix, &expiresAtUnix, &lastSeenAtUnix,
+		&session.UserAgent, &session.IPAddress, &data)
 	if errors.Is(sql.ErrNoRows, err) {
 		return session, sessions.ErrSessionNotFound
+	} else if err != nil {
+		return session, err
 	}
+	session.Data, err = sessions.DecodeSessionData(data)
+	session.CreatedAt = time.Unix(createdAtUnix, 0)
 	session.ExpiresAt = time.Unix(expiresAtUnix, 0)
+	session.LastSeenAt = time.Unix(lastSeenAtUnix, 0)
 	session.UserId = storedUserID
 	return session, err
 }
+
+// Deletes up to limit sessions that expired before the given time from the Postgres store, returning how many were
+// deleted
+func (pg *PostgresAuthStore) DeleteExpiredSessions(ctx context.Context, before time.Time, limit int) (int, error) {
+	var limitArg any // LIMIT NULL means no limit
+	if limit > 0 {
+		limitArg = limit
+	}
+	deleteExpiredQuery := `
+	DELETE FROM {{sessions}}
+	WHERE id IN (
+		SELECT id FROM {{sessions}}
+		WHERE expires_at < $1
+		LIMIT $2
+	)
+	`
+	result, err := pg.DB.ExecContext(ctx, pg.query(deleteExpiredQuery), before.Unix(), limitArg)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
+
+// Update session expiry, last seen time and data in Postgres store
+func (pg *PostgresAuthStore) UpdateSession(ctx context.Context, session sessions.Session) error {
+	data, err := session.Data.Encode()
+	if err != nil {
+		return err
+	}
+	updateSessionQuery := `
+	UPDATE {{sessions}}
+	SET expires_at = $2, last_seen_at = $3, data = $4::text::jsonb
+	WHERE id = $1
+	`
+	result, err := pg.DB.ExecContext(ctx, pg.query(updateSessionQuery), sessionDigest(string(session.Id)), session.ExpiresAt.Unix(), session.LastSeenAt.Unix(), data)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sessions.ErrSessionNotFound
+	}
+	return nil
+}
+
+// Update session data in Postgres store, leaving its expiry and last seen time as they are
+func (pg *PostgresAuthStore) UpdateSessionData(ctx context.Context, sessionId string, data sessions.SessionData) error {
+	encoded, err := data.Encode()
+	if err != nil {
+		return err
+	}
+	updateDataQuery := `
+	UPDATE {{sessions}}
+	SET data = $2::text::jsonb
+	WHERE id = $1
+	`
+	result, err := pg.DB.ExecContext(ctx, pg.query(updateDataQuery), sessionDigest(sessionId), encoded)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sessions.ErrSessionNotFound
+	}
+	return nil
+}
+
+// Returns all sessions belonging to a user in the Postgres store, with ids made by sessions.SessionIdFromDigest
+func (pg *PostgresAuthStore) ListSessionsByUserId(ctx context.Context, userId string) ([]sessions.Session, error) {
+	query := `
+	SELECT id, created_at, expires_at, last_seen_at, user_agent, ip_address, data::text
+	FROM {{sessions}}
+	WHERE user_id = $1
+	ORDER BY created_at
+	`
+	rows, err := pg.DB.QueryContext(ctx, pg.query(query), userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []sessions.Session
+	for rows.Next() {
+		session := sessions.Session{UserId: userId}
+		var digest, data string
+		var createdAtUnix, expiresAtUnix, lastSeenAtUnix int64
+		err := rows.Scan(&digest, &createdAtUnix, &expiresAtUnix, &lastSeenAtUnix, &session.UserAgent, &session.IPAddress, &data)
+		if err != nil {
+			return nil, err
+		}
+		session.Data, err = sessions.DecodeSessionData(data)
+		if err != nil {
+			return nil, err
+		}
+		session.Id = storedSessionId(digest)
+		session.CreatedAt = time.Unix(createdAtUnix, 0)
+		session.ExpiresAt = time.Unix(expiresAtUnix, 0)
+		session.LastSeenAt = time.Unix(lastSeenAtUnix, 0)
+		list = append(list, session)
+	}
+	return list, rows.Err()
+}
+
+// Deletes all sessions belonging to a user except the given one in the Postgres store
+func (pg *PostgresAuthStore) DeleteSessionsByUserId(ctx context.Context, userId string, exceptSessionId string) error {
+	_, err := pg.DB.ExecContext(ctx, pg.query(`DELETE FROM {{sessions}} WHERE user_id = $1 AND id <> $2`), userId, sessionDigest(exceptSessionId))
+	return err
+}
+
+// Load login throttling state in Postgres store, returning an empty state if there is none
+func (pg *PostgresAuthStore) LoadLoginAttempts(ctx context.Context, key string) (sessions.LoginAttempts, error) {
+	a := sessions.LoginAttempts{Key: key}
+	var updatedAtMilli, lockedUntilMilli int64
+	query := `SELECT tokens, updated_at, failures, locked_until FROM {{login_attempts}} WHERE attempt_key = $1`
+	err := pg.DB.QueryRowContext(ctx, pg.query(query), key).Scan(&a.Tokens, &updatedAtMilli, &a.Failures, &lockedUntilMilli)
+	if errors.Is(err, sql.ErrNoRows) {
+		return a, nil
+	} else if err != nil {
+		return a, err
+	}
+	a.UpdatedAt = time.UnixMilli(updatedAtMilli)
+	a.LockedUntil = time.UnixMilli(lockedUntilMilli)
+	return a, nil
+}
+
+// Save login throttling state in Postgres store
+func (pg *PostgresAuthStore) SaveLoginAttempts(ctx context.Context, a sessions.LoginAttempts) error {
+	saveLoginAttemptsQuery := `
+		INSERT INTO {{login_attempts}} (attempt_key, tokens, updated_at, failures, locked_until)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (attempt_key) DO UPDATE SET
+		tokens = EXCLUDED.tokens,
+		updated_at = EXCLUDED.updated_at,
+		failures = EXCLUDED.failures,
+		locked_until = EXCLUDED.locked_until
+		`
+	_, err := pg.DB.ExecContext(ctx, pg.query(saveLoginAttemptsQuery), a.Key, a.Tokens, a.UpdatedAt.UnixMilli(), a.Failures, a.LockedUntil.UnixMilli())
+	return err
+}
+
+// Delete login throttling state in Postgres store
+func (pg *PostgresAuthStore) DeleteLoginAttempts(ctx context.Context, key string) error {
+	_, err := pg.DB.ExecContext(ctx, pg.query(`DELETE FROM {{login_attempts}} WHERE attempt_key = $1`), key)
+	return err
+}
+
+// Delete up to limit keys of login throttling state whose last attempt and lockout both ended before the given time
+// in Postgres store
+func (pg *PostgresAuthStore) DeleteLoginAttemptsBefore(ctx context.Context, before time.Time, limit int) (int, error) {
+	var limitArg any // LIMIT NULL means no limit
+	if limit > 0 {
+		limitArg = limit
+	}
+	deleteStaleQuery := `
+	DELETE FROM {{login_attempts}}
+	WHERE attempt_key IN (
+		SELECT attempt_key FROM {{login_attempts}}
+		WHERE updated_at < $1 AND locked_until < $1
+		LIMIT $2
+	)
+	`
+	result, err := pg.DB.ExecContext(ctx, pg.query(deleteStaleQuery), before.UnixMilli(), limitArg)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
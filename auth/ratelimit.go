@@ -0,0 +1,358 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// A LoginLimiter throttles login attempts per key. LoginHandler checks a key for the username and a key for the
+// client IP address on every attempt.
+type LoginLimiter interface {
+	// Counts an attempt against the key and returns zero if it may go ahead, or how long the caller has to wait
+	// before trying again if the key is out of attempts or locked out.
+	Allow(ctx context.Context, key string) (time.Duration, error)
+	// Records a failed login for the key, which locks the key out once too many failures have piled up.
+	Failure(ctx context.Context, key string) error
+	// Records a successful login for the key, clearing its failures.
+	Success(ctx context.Context, key string) error
+	// Clears all throttling state for the key, lifting any lockout.
+	Unlock(ctx context.Context, key string) error
+}
+
+// Settings shared by the built-in limiters.
+type LimiterConfig struct {
+	// The number of attempts a key can make in quick succession.
+	Burst int
+	// How often a key gets back one attempt, up to Burst.
+	RefillEvery time.Duration
+	// The number of consecutive failed logins a key can have before it is locked out.
+	MaxFailures int
+	// How long the first lockout lasts. Every further failure doubles it, up to MaxLockout, or without a cap other
+	// than the largest time.Duration if MaxLockout is zero.
+	Lockout    time.Duration
+	MaxLockout time.Duration
+	// How long the state of a key is kept after its last attempt or the end of its lockout, whichever is later.
+	// Failures older than that are forgotten, so that keys which never log in successfully, such as usernames
+	// that don't exist, don't pile up. Zero means a day.
+	Retention time.Duration
+	// Tells the time attempts are recorded at. Nil means the system clock.
+	Clock sessions.Clock
+}
+
+// how long the state of a key is kept when LimiterConfig.Retention is zero
+const defaultLimiterRetention = 24 * time.Hour
+
+// Returns the default limiter settings: bursts of 10 attempts refilling at one every 6 seconds, lockouts starting at
+// a minute after 5 consecutive failures, growing to at most an hour, and state kept for a day after a key's last
+// attempt or lockout.
+func DefaultLimiterConfig() LimiterConfig {
+	return LimiterConfig{
+		Burst:       10,
+		RefillEvery: 6 * time.Second,
+		MaxFailures: 5,
+		Lockout:     time.Minute,
+		MaxLockout:  time.Hour,
+		Retention:   defaultLimiterRetention,
+	}
+}
+
+// returns how long the state of a key is kept
+func (c LimiterConfig) retention() time.Duration {
+	if c.Retention <= 0 {
+		return defaultLimiterRetention
+	}
+	return c.Retention
+}
+
+// Reports whether the state has outlived the retention window, counted from its last attempt or the end of its
+// lockout, whichever is later.
+func (c LimiterConfig) stale(a *sessions.LoginAttempts, now time.Time) bool {
+	if a.UpdatedAt.IsZero() && a.LockedUntil.IsZero() {
+		return false
+	}
+	last := a.UpdatedAt
+	if a.LockedUntil.After(last) {
+		last = a.LockedUntil
+	}
+	return !now.Before(last.Add(c.retention()))
+}
+
+// resets state that has outlived the retention window to that of a key that has never been seen
+func (c LimiterConfig) forgetStale(a *sessions.LoginAttempts, now time.Time) {
+	if c.stale(a, now) {
+		*a = sessions.LoginAttempts{Key: a.Key}
+	}
+}
+
+// Updates the state for an attempt at the given time and returns how long to wait if it is not allowed.
+func (c LimiterConfig) allow(a *sessions.LoginAttempts, now time.Time) time.Duration {
+	c.forgetStale(a, now)
+	if now.Before(a.LockedUntil) {
+		return a.LockedUntil.Sub(now)
+	}
+	c.refill(a, now)
+	if a.Tokens < 1 {
+		return time.Duration((1 - a.Tokens) * float64(c.RefillEvery))
+	}
+	a.Tokens--
+	return 0
+}
+
+// tops up the token bucket for the time that has passed since it was last updated
+func (c LimiterConfig) refill(a *sessions.LoginAttempts, now time.Time) {
+	if a.UpdatedAt.IsZero() {
+		a.Tokens = float64(c.Burst)
+	} else if c.RefillEvery > 0 {
+		a.Tokens += float64(now.Sub(a.UpdatedAt)) / float64(c.RefillEvery)
+	}
+	a.Tokens = math.Min(a.Tokens, float64(c.Burst))
+	a.UpdatedAt = now
+}
+
+// Records a failure, locking the key out with an exponentially growing lockout once MaxFailures is passed.
+func (c LimiterConfig) fail(a *sessions.LoginAttempts, now time.Time) {
+	c.forgetStale(a, now)
+	a.Failures++
+	if a.Failures < c.MaxFailures {
+		return
+	}
+	lockout := c.Lockout
+	for i := c.MaxFailures; i < a.Failures; i++ {
+		if c.MaxLockout > 0 && lockout >= c.MaxLockout {
+			break
+		}
+		// doubling any further would overflow into a negative lockout, unlocking the most persistent attacker
+		if lockout > math.MaxInt64/2 {
+			lockout = math.MaxInt64
+			break
+		}
+		lockout *= 2
+	}
+	if c.MaxLockout > 0 && lockout > c.MaxLockout {
+		lockout = c.MaxLockout
+	}
+	a.LockedUntil = now.Add(lockout)
+}
+
+// Reports whether the state can be dropped: it has outlived the retention window, or it is no different from a key
+// that has never been seen.
+func (c LimiterConfig) idle(a *sessions.LoginAttempts, now time.Time) bool {
+	if c.stale(a, now) {
+		return true
+	}
+	if a.Failures > 0 || now.Before(a.LockedUntil) {
+		return false
+	}
+	c.refill(a, now)
+	return a.Tokens >= float64(c.Burst)
+}
+
+// A LoginLimiter that keeps its state in memory, for applications running as a single instance.
+type MemoryLimiter struct {
+	Config LimiterConfig
+
+	mu       sync.Mutex
+	attempts map[string]*sessions.LoginAttempts
+	calls    int
+}
+
+// Returns a new in-memory limiter with the given settings.
+func NewMemoryLimiter(cfg LimiterConfig) *MemoryLimiter {
+	return &MemoryLimiter{
+		Config:   cfg,
+		attempts: make(map[string]*sessions.LoginAttempts),
+	}
+}
+
+// how many calls go by between sweeps for idle keys
+const memoryLimiterSweepEvery = 1024
+
+// returns the state for a key, creating it if needed. Must be called with the lock held.
+func (m *MemoryLimiter) entry(key string, now time.Time) *sessions.LoginAttempts {
+	m.calls++
+	if m.calls%memoryLimiterSweepEvery == 0 {
+		for k, a := range m.attempts {
+			if m.Config.idle(a, now) {
+				delete(m.attempts, k)
+			}
+		}
+	}
+	a, ok := m.attempts[key]
+	if !ok {
+		a = &sessions.LoginAttempts{Key: key}
+		m.attempts[key] = a
+	}
+	return a
+}
+
+func (m *MemoryLimiter) Allow(_ context.Context, key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := clockNow(m.Config.Clock)
+	return m.Config.allow(m.entry(key, now), now), nil
+}
+
+func (m *MemoryLimiter) Failure(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := clockNow(m.Config.Clock)
+	m.Config.fail(m.entry(key, now), now)
+	return nil
+}
+
+func (m *MemoryLimiter) Success(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if a, ok := m.attempts[key]; ok {
+		a.Failures = 0
+	}
+	return nil
+}
+
+func (m *MemoryLimiter) Unlock(_ context.Context, key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.attempts, key)
+	return nil
+}
+
+// A LoginLimiter that keeps its state in a LoginAttemptStore such as SQLiteAuthStore or PostgresAuthStore, so that
+// every instance of an application behind a load balancer sees the same attempts and lockouts. Updates are not
+// atomic across instances, so concurrent attempts for the same key may occasionally be undercounted.
+//
+// State past the Config's retention window is ignored, and a Reaper deletes it from stores that implement
+// sessions.LoginAttemptSweeper. NewAuthContext sets that up when both WithLoginLimiter and WithSessionReaper are
+// given.
+type StoreLimiter struct {
+	Store  sessions.LoginAttemptStore
+	Config LimiterConfig
+}
+
+// Returns a new limiter that keeps its state in the given store.
+func NewStoreLimiter(store sessions.LoginAttemptStore, cfg LimiterConfig) *StoreLimiter {
+	if r, ok := store.(*RedisAuthStore); ok && r.attemptRetention < cfg.retention() {
+		log.Printf("Warning: the Redis store keeps login attempts for %s, less than the limiter's retention of %s",
+			r.attemptRetention, cfg.retention())
+	}
+	return &StoreLimiter{
+		Store:  store,
+		Config: cfg,
+	}
+}
+
+func (s *StoreLimiter) Allow(ctx context.Context, key string) (time.Duration, error) {
+	a, err := s.Store.LoadLoginAttempts(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+	wait := s.Config.allow(&a, clockNow(s.Config.Clock))
+	if wait > 0 {
+		return wait, nil
+	}
+	return 0, s.Store.SaveLoginAttempts(ctx, a)
+}
+
+func (s *StoreLimiter) Failure(ctx context.Context, key string) error {
+	a, err := s.Store.LoadLoginAttempts(ctx, key)
+	if err != nil {
+		return err
+	}
+	s.Config.fail(&a, clockNow(s.Config.Clock))
+	return s.Store.SaveLoginAttempts(ctx, a)
+}
+
+func (s *StoreLimiter) Success(ctx context.Context, key string) error {
+	a, err := s.Store.LoadLoginAttempts(ctx, key)
+	if err != nil {
+		return err
+	}
+	if a.Failures == 0 {
+		return nil
+	}
+	a.Failures = 0
+	return s.Store.SaveLoginAttempts(ctx, a)
+}
+
+func (s *StoreLimiter) Unlock(ctx context.Context, key string) error {
+	return s.Store.DeleteLoginAttempts(ctx, key)
+}
+
+// Returns the IP address of the client from the request's RemoteAddr. This is the default way the AuthContext
+// identifies clients for rate limiting. Behind a reverse proxy, use WithClientIP to read the address the proxy
+// forwards instead.
+func RemoteAddrIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// returns the client IP address of a request using ClientIP, falling back to RemoteAddrIP
+func (ac *AuthContext) clientIP(r *http.Request) string {
+	if ac.ClientIP != nil {
+		return ac.ClientIP(r)
+	}
+	return RemoteAddrIP(r)
+}
+
+// the limiter keys used for a username and a client IP address
+func usernameLimiterKey(username string) string {
+	return "user:" + username
+}
+
+func ipLimiterKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Lifts any login lockout on a username and clears its failed attempts.
+func (ac *AuthContext) UnlockUsername(ctx context.Context, username string) error {
+	if ac.Limiter == nil {
+		return nil
+	}
+	if ac.Policy != nil {
+		username = ac.Policy.NormalizeUsername(username)
+	}
+	return ac.Limiter.Unlock(ctx, usernameLimiterKey(username))
+}
+
+// Lifts any login lockout on a client IP address and clears its failed attempts.
+func (ac *AuthContext) UnlockIP(ctx context.Context, ip string) error {
+	if ac.Limiter == nil {
+		return nil
+	}
+	return ac.Limiter.Unlock(ctx, ipLimiterKey(ip))
+}
+
+// the limiter keys a login request is counted against
+func (ac *AuthContext) loginLimiterKeys(r *http.Request, username string) []string {
+	if ac.Policy != nil {
+		username = ac.Policy.NormalizeUsername(username)
+	}
+	keys := []string{usernameLimiterKey(username)}
+	if ac.ClientIP != nil {
+		keys = append(keys, ipLimiterKey(ac.ClientIP(r)))
+	}
+	return keys
+}
+
+// Counts a login attempt against every key and returns the longest wait if any of them is throttled. Limiter
+// errors are returned so the caller can decide whether to fail open.
+func (ac *AuthContext) allowLogin(ctx context.Context, keys []string) (time.Duration, error) {
+	var longest time.Duration
+	for _, key := range keys {
+		wait, err := ac.Limiter.Allow(ctx, key)
+		if err != nil {
+			return 0, err
+		}
+		longest = max(longest, wait)
+	}
+	return longest, nil
+}
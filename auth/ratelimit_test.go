@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// a clock that only moves when told to
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func testLimiterConfig(clock *fakeClock) LimiterConfig {
+	return LimiterConfig{
+		Burst:       10,
+		RefillEvery: time.Second,
+		MaxFailures: 3,
+		Lockout:     time.Minute,
+		MaxLockout:  time.Hour,
+		Retention:   time.Hour,
+		Clock:       clock,
+	}
+}
+
+func TestMemoryLimiterForgetsSprayedFailures(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := NewMemoryLimiter(testLimiterConfig(clock))
+
+	// a failed login against each of many usernames that don't exist, none of which ever succeeds
+	for i := range 5000 {
+		key := usernameLimiterKey(fmt.Sprint("sprayed", i))
+		limiter.Allow(t.Context(), key)
+		limiter.Failure(t.Context(), key)
+	}
+	if n := len(limiter.attempts); n < 4000 {
+		t.Fatalf("the limiter holds %d keys after the spray, want most of the 5000 still within retention", n)
+	}
+
+	clock.now = clock.now.Add(time.Hour + time.Second)
+	for range memoryLimiterSweepEvery {
+		limiter.Allow(t.Context(), ipLimiterKey("192.0.2.1"))
+	}
+	if n := len(limiter.attempts); n > 1 {
+		t.Errorf("the limiter holds %d keys once the spray is past retention, want only the one still in use", n)
+	}
+}
+
+func TestUncappedLockoutSaturates(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)}
+	cfg := testLimiterConfig(clock)
+	cfg.MaxLockout = 0
+	limiter := NewMemoryLimiter(cfg)
+
+	// every failure past MaxFailures doubles the lockout, which never wraps around to let the key back in
+	var last time.Duration
+	for i := 1; i <= 40; i++ {
+		limiter.Failure(t.Context(), "user:alice")
+		if i < cfg.MaxFailures {
+			continue
+		}
+		wait, _ := limiter.Allow(t.Context(), "user:alice")
+		if wait <= 0 || wait < last {
+			t.Fatalf("after %d failures alice has to wait %s, down from %s", i, wait, last)
+		}
+		last = wait
+	}
+}
+
+func TestStoreLimiter(t *testing.T) {
+	db := openSQLite(t)
+	store, err := NewSQLiteStore(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &fakeClock{now: time.Date(2025, time.January, 1, 12, 0, 0, 0, time.UTC)}
+	limiter := NewStoreLimiter(store, testLimiterConfig(clock))
+	ctx := t.Context()
+	attempt := func(key string, succeed bool) time.Duration {
+		t.Helper()
+		wait, err := limiter.Allow(ctx, key)
+		if err != nil {
+			t.Fatalf("Allow(%s) error = %v", key, err)
+		}
+		if wait > 0 {
+			return wait
+		}
+		if succeed {
+			err = limiter.Success(ctx, key)
+		} else {
+			err = limiter.Failure(ctx, key)
+		}
+		if err != nil {
+			t.Fatalf("recording an attempt for %s: %v", key, err)
+		}
+		return 0
+	}
+
+	for range 3 {
+		attempt("user:alice", false)
+	}
+	if wait := attempt("user:alice", true); wait != time.Minute {
+		t.Errorf("after 3 failures alice has to wait %s, want a minute", wait)
+	}
+	if err := limiter.Unlock(ctx, "user:alice"); err != nil {
+		t.Fatalf("Unlock() error = %v", err)
+	}
+	if wait := attempt("user:alice", true); wait != 0 {
+		t.Errorf("after unlocking alice has to wait %s", wait)
+	}
+
+	// failures are forgotten once they are past retention, and the reaper deletes them
+	attempt("user:mallory", false)
+	attempt("user:mallory", false)
+	clock.now = clock.now.Add(time.Hour + time.Second)
+	attempt("user:bob", false)
+	if a, _ := store.LoadLoginAttempts(ctx, "user:mallory"); a.Failures != 2 {
+		t.Fatalf("mallory's stored failures = %d, want 2 until they are swept", a.Failures)
+	}
+	attempt("user:mallory", false)
+	if a, _ := store.LoadLoginAttempts(ctx, "user:mallory"); a.Failures != 1 {
+		t.Errorf("mallory's failures after retention = %d, want the stale ones forgotten", a.Failures)
+	}
+
+	ac := NewAuthContext(store, sessions.NewKeyringFromSecret("test signing secret"), time.Hour,
+		WithPasswordHasher(&slowHasher{}), WithLoginLimiter(limiter), WithSessionReaper(time.Hour, 1), WithClock(clock))
+	ac.Close()
+	reaper := ac.Reaper
+	if reaper.Attempts == nil || reaper.AttemptRetention != limiter.Config.Retention {
+		t.Fatal("NewAuthContext did not have the reaper sweep the limiter's store")
+	}
+	clock.now = clock.now.Add(time.Hour + time.Second)
+	attempt("user:dave", false)
+	if _, err := reaper.Reap(ctx); err != nil {
+		t.Fatalf("Reap() error = %v", err)
+	}
+	var keys []string
+	rows, err := db.QueryContext(ctx, `SELECT attempt_key FROM login_attempts ORDER BY attempt_key`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var key string
+		rows.Scan(&key)
+		keys = append(keys, key)
+	}
+	if fmt.Sprint(keys) != "[user:dave]" {
+		t.Errorf("after reaping the stored keys are %v, want only the one used within retention", keys)
+	}
+}
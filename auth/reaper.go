@@ -0,0 +1,126 @@
+package auth
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// the defaults used by NewReaper for a zero interval or batch size
+const (
+	defaultReapInterval  = 10 * time.Minute
+	defaultReapBatchSize = 1000
+)
+
+// A Reaper periodically deletes expired sessions from a SessionStore, so that sessions that are never presented
+// again don't pile up. Each pass deletes them in batches of BatchSize until none are left.
+type Reaper struct {
+	Store     sessions.SessionStore
+	Interval  time.Duration
+	BatchSize int
+	// When set, each pass also deletes login throttling state whose last attempt and lockout ended more than
+	// AttemptRetention ago.
+	Attempts         sessions.LoginAttemptSweeper
+	AttemptRetention time.Duration
+	// Tells the time sessions are checked against. Nil means the system clock.
+	Clock sessions.Clock
+
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// Returns a new Reaper for the store. A zero interval defaults to 10 minutes and a zero batch size to 1000.
+func NewReaper(store sessions.SessionStore, interval time.Duration, batchSize int) *Reaper {
+	if interval <= 0 {
+		interval = defaultReapInterval
+	}
+	if batchSize <= 0 {
+		batchSize = defaultReapBatchSize
+	}
+	return &Reaper{
+		Store:     store,
+		Interval:  interval,
+		BatchSize: batchSize,
+	}
+}
+
+// Starts reaping in the background, with a first pass right away. It runs until Stop is called or ctx is
+// cancelled. Calling Start on a running Reaper does nothing.
+func (r *Reaper) Start(ctx context.Context) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.cancel != nil {
+		return
+	}
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.done = make(chan struct{})
+	go r.run(ctx, r.done)
+}
+
+// Stops the Reaper and waits for a pass in progress to finish. It can be started again afterwards.
+func (r *Reaper) Stop() {
+	r.mu.Lock()
+	cancel, done := r.cancel, r.done
+	r.cancel, r.done = nil, nil
+	r.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+func (r *Reaper) run(ctx context.Context, done chan struct{}) {
+	defer close(done)
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+	for {
+		if _, err := r.Reap(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("Error deleting expired sessions or login attempts: %s", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Deletes every session that has expired by now, one batch at a time, and returns how many were deleted. Stale
+// login attempts are deleted afterwards when Attempts is set. A BatchSize of zero deletes them all at once. It stops
+// early if ctx is cancelled.
+func (r *Reaper) Reap(ctx context.Context) (int, error) {
+	now := clockNow(r.Clock)
+	total, err := r.inBatches(ctx, func(limit int) (int, error) {
+		return r.Store.DeleteExpiredSessions(ctx, now, limit)
+	})
+	if err != nil || r.Attempts == nil {
+		return total, err
+	}
+	_, err = r.inBatches(ctx, func(limit int) (int, error) {
+		return r.Attempts.DeleteLoginAttemptsBefore(ctx, now.Add(-r.AttemptRetention), limit)
+	})
+	return total, err
+}
+
+// calls del with BatchSize until it deletes less than a full batch, returning how many were deleted in total
+func (r *Reaper) inBatches(ctx context.Context, del func(limit int) (int, error)) (int, error) {
+	total := 0
+	for {
+		deleted, err := del(r.BatchSize)
+		total += deleted
+		if err != nil {
+			return total, err
+		}
+		if r.BatchSize <= 0 || deleted < r.BatchSize {
+			return total, nil
+		}
+		if err := ctx.Err(); err != nil {
+			return total, err
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package auth
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+func TestReaper(t *testing.T) {
+	store := NewMemoryAuthStore()
+	now := time.Now()
+	for i := range 25 {
+		store.SaveSession(t.Context(), sessions.Session{Id: sessions.SessionId(fmt.Sprint("expired", i)), UserId: "01", ExpiresAt: now.Add(-time.Minute)})
+	}
+	store.SaveSession(t.Context(), sessions.Session{Id: "active", UserId: "01", ExpiresAt: now.Add(time.Hour)})
+
+	deleted, err := NewReaper(store, time.Hour, 10).Reap(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted != 25 {
+		t.Errorf("deleted %d sessions, want 25", deleted)
+	}
+	if remaining, _ := store.ListSessionsByUserId(t.Context(), "01"); len(remaining) != 1 || remaining[0].Id != "active" {
+		t.Errorf("remaining sessions = %v, want only the active one", remaining)
+	}
+
+	store.SaveSession(t.Context(), sessions.Session{Id: "expired", UserId: "01", ExpiresAt: now.Add(-time.Minute)})
+	ac := NewAuthContext(store, sessions.NewKeyringFromSecret("test signing secret"), time.Hour, WithSessionReaper(time.Hour, 10))
+	deadline := time.Now().Add(time.Second)
+	for {
+		if _, err := store.LoadSessionById(t.Context(), "expired"); err == sessions.ErrSessionNotFound {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("the AuthContext's reaper did not delete the expired session after starting")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	ac.Close()
+	ac.Close()
+}
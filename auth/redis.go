@@ -0,0 +1,552 @@
+package auth
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// Settings for connecting RedisAuthStore to a Redis or Valkey server.
+type RedisConfig struct {
+	// The host:port of the server.
+	Addr string
+	// Credentials for AUTH, if the server requires them. Username is only needed for ACL users other than default.
+	Username string
+	Password string
+	// The database to SELECT.
+	DB int
+	// Prepended to every key the store uses, so that several applications can share a server.
+	KeyPrefix string
+	// The maximum number of open connections.
+	PoolSize    int
+	DialTimeout time.Duration
+	// Connects over TLS with these settings if set.
+	TLS *tls.Config
+	// Tells the time that login attempts without timestamps of their own expire from. Nil means the system clock.
+	Clock sessions.Clock
+	// How long login attempts are kept after their last update or the end of their lockout. Zero means a day, the
+	// default LimiterConfig.Retention. Set it to at least the Retention of a StoreLimiter using the store.
+	LoginAttemptRetention time.Duration
+}
+
+// Returns settings for a server on localhost:6379 without authentication, with keys prefixed "go-sessions:" and a
+// pool of up to 10 connections.
+func DefaultRedisConfig() RedisConfig {
+	return RedisConfig{
+		Addr:        "localhost:6379",
+		KeyPrefix:   "go-sessions:",
+		PoolSize:    10,
+		DialTimeout: 5 * time.Second,
+	}
+}
+
+// An AuthStore and LoginAttemptStore that keeps its data in Redis, or anything else that speaks the Redis protocol
+// such as Valkey. It needs Redis 7 or later.
+//
+// Each session is a key that Redis expires at the session's ExpiresAt, so expired sessions don't need to be reaped.
+// The ids of each user's sessions are kept in a set, which is what ListSessionsByUserId, DeleteSessionsByUserId and
+// DeleteUserByUserId work from. Users are stored as keys by id along with a key per username pointing at the id.
+type RedisAuthStore struct {
+	client           *respClient
+	prefix           string
+	clock            sessions.Clock
+	attemptRetention time.Duration
+}
+
+var ErrRedisStoreClosed = errors.New("The Redis store has been closed")
+var ErrRedisTransactionConflict = errors.New("The Redis keys kept changing during the transaction")
+
+// Returns a new Redis AuthStore and checks that the server can be reached.
+func NewRedisAuthStore(ctx context.Context, cfg RedisConfig) (*RedisAuthStore, error) {
+	store := &RedisAuthStore{
+		client:           newRESPClient(cfg),
+		prefix:           cfg.KeyPrefix,
+		clock:            cfg.Clock,
+		attemptRetention: cfg.LoginAttemptRetention,
+	}
+	if store.attemptRetention <= 0 {
+		store.attemptRetention = defaultLimiterRetention
+	}
+	if _, err := store.client.do(ctx, "PING"); err != nil {
+		store.client.close()
+		return nil, err
+	}
+	return store, nil
+}
+
+// Closes the store's connections to the server.
+func (r *RedisAuthStore) Close() error {
+	return r.client.close()
+}
+
+func (r *RedisAuthStore) userKey(id string) string         { return r.prefix + "user:" + id }
+func (r *RedisAuthStore) usernameKey(name string) string   { return r.prefix + "username:" + name }
+func (r *RedisAuthStore) sessionKey(id string) string      { return r.prefix + "session:" + id }
+func (r *RedisAuthStore) userSessionsKey(id string) string { return r.prefix + "user_sessions:" + id }
+func (r *RedisAuthStore) loginAttemptsKey(k string) string { return r.prefix + "login_attempts:" + k }
+
+// the JSON stored under a user key
+type redisUser struct {
+	UserId         string `json:"user_id"`
+	Username       string `json:"username"`
+	HashedPassword string `json:"hashed_password"`
+}
+
+// the JSON stored under a session key
+type redisSession struct {
+	UserId     string               `json:"user_id"`
+	CreatedAt  time.Time            `json:"created_at"`
+	ExpiresAt  time.Time            `json:"expires_at"`
+	LastSeenAt time.Time            `json:"last_seen_at"`
+	UserAgent  string               `json:"user_agent"`
+	IPAddress  string               `json:"ip_address"`
+	Data       sessions.SessionData `json:"data,omitempty"`
+}
+
+// the JSON stored under a login attempts key
+type redisLoginAttempts struct {
+	Tokens      float64   `json:"tokens"`
+	UpdatedAt   time.Time `json:"updated_at"`
+	Failures    int       `json:"failures"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// returns the JSON stored under the key of a session
+func redisSessionRecord(s sessions.Session) string {
+	return encodeJSON(redisSession{
+		UserId:     s.UserId,
+		CreatedAt:  s.CreatedAt,
+		ExpiresAt:  s.ExpiresAt,
+		LastSeenAt: s.LastSeenAt,
+		UserAgent:  s.UserAgent,
+		IPAddress:  s.IPAddress,
+		Data:       s.Data,
+	})
+}
+
+func encodeJSON(v any) string {
+	b, _ := json.Marshal(v)
+	return string(b)
+}
+
+// the argument to PXAT for a time, which must be positive
+func unixMilliArg(t time.Time) string {
+	return strconv.FormatInt(max(t.UnixMilli(), 1), 10)
+}
+
+func (r *RedisAuthStore) SaveUser(ctx context.Context, u sessions.User) error {
+	// the username is claimed first so that two users can't end up with the same one
+	_, err := r.client.do(ctx, "SET", r.usernameKey(u.Username), u.UserId, "NX")
+	if errors.Is(err, errNilReply) {
+		return sessions.ErrUserExists
+	} else if err != nil {
+		return err
+	}
+	record := encodeJSON(redisUser{UserId: u.UserId, Username: u.Username, HashedPassword: u.HashedPassword})
+	_, err = r.client.do(ctx, "SET", r.userKey(u.UserId), record, "NX")
+	if err != nil {
+		r.client.do(context.WithoutCancel(ctx), "DEL", r.usernameKey(u.Username))
+		if errors.Is(err, errNilReply) {
+			return sessions.ErrUserExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (r *RedisAuthStore) LoadUserByUserId(ctx context.Context, id string) (sessions.User, error) {
+	reply, err := r.client.do(ctx, "GET", r.userKey(id))
+	if errors.Is(err, errNilReply) {
+		return sessions.User{UserId: id}, sessions.ErrUserNotFound
+	} else if err != nil {
+		return sessions.User{UserId: id}, err
+	}
+	var record redisUser
+	if err := json.Unmarshal([]byte(reply.(string)), &record); err != nil {
+		return sessions.User{UserId: id}, err
+	}
+	return sessions.User{UserId: record.UserId, Username: record.Username, HashedPassword: record.HashedPassword}, nil
+}
+
+func (r *RedisAuthStore) LoadUserByUsername(ctx context.Context, username string) (sessions.User, error) {
+	reply, err := r.client.do(ctx, "GET", r.usernameKey(username))
+	if errors.Is(err, errNilReply) {
+		return sessions.User{Username: username}, sessions.ErrUserNotFound
+	} else if err != nil {
+		return sessions.User{Username: username}, err
+	}
+	return r.LoadUserByUserId(ctx, reply.(string))
+}
+
+// Updates the username and hashed password of an existing user. A new username is claimed before the old one is
+// released.
+func (r *RedisAuthStore) UpdateUser(ctx context.Context, u sessions.User) error {
+	old, err := r.LoadUserByUserId(ctx, u.UserId)
+	if err != nil {
+		return err
+	}
+	if old.Username != u.Username {
+		_, err := r.client.do(ctx, "SET", r.usernameKey(u.Username), u.UserId, "NX")
+		if errors.Is(err, errNilReply) {
+			return sessions.ErrUserExists
+		} else if err != nil {
+			return err
+		}
+	}
+	record := encodeJSON(redisUser{UserId: u.UserId, Username: u.Username, HashedPassword: u.HashedPassword})
+	_, err = r.client.do(ctx, "SET", r.userKey(u.UserId), record, "XX")
+	if errors.Is(err, errNilReply) {
+		err = sessions.ErrUserNotFound
+	}
+	if err != nil {
+		if old.Username != u.Username {
+			r.client.do(context.WithoutCancel(ctx), "DEL", r.usernameKey(u.Username))
+		}
+		return err
+	}
+	if old.Username != u.Username {
+		_, err = r.client.do(ctx, "DEL", r.usernameKey(old.Username))
+	}
+	return err
+}
+
+// Deletes a user and all of their sessions
+func (r *RedisAuthStore) DeleteUserByUserId(ctx context.Context, id string) error {
+	u, err := r.LoadUserByUserId(ctx, id)
+	if err != nil {
+		return err
+	}
+	reply, err := r.client.do(ctx, "SMEMBERS", r.userSessionsKey(id))
+	if err != nil {
+		return err
+	}
+	keys := []string{"DEL", r.userKey(id), r.usernameKey(u.Username), r.userSessionsKey(id)}
+	for _, sid := range reply.([]any) {
+		keys = append(keys, r.sessionKey(sid.(string)))
+	}
+	_, err = r.client.do(ctx, keys...)
+	return err
+}
+
+func (r *RedisAuthStore) SaveSession(ctx context.Context, s sessions.Session) error {
+	record := redisSessionRecord(s)
+	expiresAt := unixMilliArg(s.ExpiresAt)
+	_, err := r.client.do(ctx, "SET", r.sessionKey(string(s.Id)), record, "PXAT", expiresAt, "NX")
+	if errors.Is(err, errNilReply) {
+		return sessions.ErrSessionExists
+	} else if err != nil {
+		return err
+	}
+	if s.UserId == "" {
+		// guest sessions belong to no user, so there is no set to add them to
+		return nil
+	}
+
+	// the user's set lives as long as their longest-lived session; NX gives a new set an expiry at all, since GT
+	// treats a set without one as never expiring
+	setKey := r.userSessionsKey(s.UserId)
+	replies, err := r.client.pipeline(ctx, [][]string{
+		{"SADD", setKey, string(s.Id)},
+		{"PEXPIREAT", setKey, expiresAt, "NX"},
+		{"PEXPIREAT", setKey, expiresAt, "GT"},
+	})
+	if err == nil {
+		err = firstReplyError(replies)
+	}
+	if err != nil {
+		// a session missing from its user's set could not be revoked with the rest of them
+		r.client.do(context.WithoutCancel(ctx), "DEL", r.sessionKey(string(s.Id)))
+		return err
+	}
+	return nil
+}
+
+// Deletes the session with the old id, if it still exists, and saves the new session in its place, all in one
+// MULTI/EXEC transaction. Both keys are watched, so a session saved under the new id in the meantime is reported as
+// ErrSessionExists rather than overwritten.
+func (r *RedisAuthStore) ReplaceSession(ctx context.Context, oldSessionId string, s sessions.Session) error {
+	newKey, oldKey := r.sessionKey(string(s.Id)), r.sessionKey(oldSessionId)
+	expiresAt := unixMilliArg(s.ExpiresAt)
+	reads := [][]string{{"EXISTS", newKey}, {"GET", oldKey}}
+	replies, err := r.client.transaction(ctx, []string{newKey, oldKey}, reads, func(replies []any) ([][]string, error) {
+		if err, ok := replies[0].(error); ok {
+			return nil, err
+		}
+		if n, _ := replies[0].(int64); n > 0 {
+			return nil, sessions.ErrSessionExists
+		}
+		cmds := [][]string{{"SET", newKey, redisSessionRecord(s), "PXAT", expiresAt}}
+		if s.UserId != "" {
+			setKey := r.userSessionsKey(s.UserId)
+			cmds = append(cmds,
+				[]string{"SADD", setKey, string(s.Id)},
+				[]string{"PEXPIREAT", setKey, expiresAt, "NX"},
+				[]string{"PEXPIREAT", setKey, expiresAt, "GT"},
+			)
+		}
+		switch old := replies[1].(type) {
+		case string:
+			stored, err := decodeRedisSession(oldSessionId, old)
+			if err != nil {
+				return nil, err
+			}
+			cmds = append(cmds, []string{"DEL", oldKey})
+			if stored.UserId != "" {
+				cmds = append(cmds, []string{"SREM", r.userSessionsKey(stored.UserId), oldSessionId})
+			}
+		case error:
+			if !errors.Is(old, errNilReply) {
+				return nil, old
+			}
+		}
+		return cmds, nil
+	})
+	if err != nil {
+		return err
+	}
+	return firstReplyError(replies)
+}
+
+// returns the first error reply of a pipeline, if any
+func firstReplyError(replies []any) error {
+	for _, reply := range replies {
+		if err, ok := reply.(error); ok {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeRedisSession(id string, value string) (sessions.Session, error) {
+	var record redisSession
+	if err := json.Unmarshal([]byte(value), &record); err != nil {
+		return sessions.Session{Id: sessions.SessionId(id)}, err
+	}
+	return sessions.Session{
+		Id:         sessions.SessionId(id),
+		UserId:     record.UserId,
+		CreatedAt:  record.CreatedAt,
+		ExpiresAt:  record.ExpiresAt,
+		LastSeenAt: record.LastSeenAt,
+		UserAgent:  record.UserAgent,
+		IPAddress:  record.IPAddress,
+		Data:       record.Data,
+	}, nil
+}
+
+func (r *RedisAuthStore) LoadSessionById(ctx context.Context, id string) (sessions.Session, error) {
+	reply, err := r.client.do(ctx, "GET", r.sessionKey(id))
+	if errors.Is(err, errNilReply) {
+		return sessions.Session{Id: sessions.SessionId(id)}, sessions.ErrSessionNotFound
+	} else if err != nil {
+		return sessions.Session{Id: sessions.SessionId(id)}, err
+	}
+	return decodeRedisSession(id, reply.(string))
+}
+
+// Updates the expiry, last seen time and data of an existing session. The key's
+// expiry moves along with ExpiresAt.
+func (r *RedisAuthStore) UpdateSession(ctx context.Context, s sessions.Session) error {
+	stored, err := r.LoadSessionById(ctx, string(s.Id))
+	if err != nil {
+		return err
+	}
+	stored.ExpiresAt = s.ExpiresAt
+	stored.LastSeenAt = s.LastSeenAt
+	stored.Data = s.Data
+	record := redisSessionRecord(stored)
+	expiresAt := unixMilliArg(s.ExpiresAt)
+	setKey := r.userSessionsKey(stored.UserId)
+	replies, err := r.client.pipeline(ctx, [][]string{
+		{"SET", r.sessionKey(string(s.Id)), record, "PXAT", expiresAt, "XX"},
+		{"PEXPIREAT", setKey, expiresAt, "GT"},
+	})
+	if err != nil {
+		return err
+	}
+	if err, _ := replies[0].(error); errors.Is(err, errNilReply) {
+		return sessions.ErrSessionNotFound
+	}
+	return firstReplyError(replies)
+}
+
+// Replaces the data of an existing session, leaving its expiry and last seen time as they are. The session is read
+// and written back with KEEPTTL in a transaction that watches its key, so a renewal in between is never undone.
+func (r *RedisAuthStore) UpdateSessionData(ctx context.Context, id string, data sessions.SessionData) error {
+	key := r.sessionKey(id)
+	replies, err := r.client.transaction(ctx, []string{key}, [][]string{{"GET", key}}, func(replies []any) ([][]string, error) {
+		switch stored := replies[0].(type) {
+		case string:
+			s, err := decodeRedisSession(id, stored)
+			if err != nil {
+				return nil, err
+			}
+			s.Data = data
+			return [][]string{{"SET", key, redisSessionRecord(s), "KEEPTTL", "XX"}}, nil
+		case error:
+			if !errors.Is(stored, errNilReply) {
+				return nil, stored
+			}
+		}
+		return nil, sessions.ErrSessionNotFound
+	})
+	if err != nil {
+		return err
+	}
+	if err, _ := replies[0].(error); errors.Is(err, errNilReply) {
+		return sessions.ErrSessionNotFound
+	}
+	return firstReplyError(replies)
+}
+
+func (r *RedisAuthStore) DeleteSessionById(ctx context.Context, id string) error {
+	reply, err := r.client.do(ctx, "GETDEL", r.sessionKey(id))
+	if errors.Is(err, errNilReply) {
+		return sessions.ErrSessionNotFound
+	} else if err != nil {
+		return err
+	}
+	s, err := decodeRedisSession(id, reply.(string))
+	if err != nil {
+		return err
+	}
+	_, err = r.client.do(ctx, "SREM", r.userSessionsKey(s.UserId), id)
+	return err
+}
+
+// Returns all sessions belonging to a user, oldest first. Ids in the user's set whose session has expired are
+// removed from it along the way.
+func (r *RedisAuthStore) ListSessionsByUserId(ctx context.Context, userId string) ([]sessions.Session, error) {
+	ids, err := r.userSessionIds(ctx, userId)
+	if err != nil || len(ids) == 0 {
+		return nil, err
+	}
+	args := []string{"MGET"}
+	for _, id := range ids {
+		args = append(args, r.sessionKey(id))
+	}
+	reply, err := r.client.do(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	var list []sessions.Session
+	stale := []string{"SREM", r.userSessionsKey(userId)}
+	for i, value := range reply.([]any) {
+		value, ok := value.(string)
+		if !ok {
+			stale = append(stale, ids[i])
+			continue
+		}
+		s, err := decodeRedisSession(ids[i], value)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+	}
+	if len(stale) > 2 {
+		if _, err := r.client.do(ctx, stale...); err != nil {
+			return nil, err
+		}
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].Id < list[j].Id
+	})
+	return list, nil
+}
+
+func (r *RedisAuthStore) userSessionIds(ctx context.Context, userId string) ([]string, error) {
+	reply, err := r.client.do(ctx, "SMEMBERS", r.userSessionsKey(userId))
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, id := range reply.([]any) {
+		ids = append(ids, id.(string))
+	}
+	return ids, nil
+}
+
+// Deletes all sessions belonging to a user except the given one
+func (r *RedisAuthStore) DeleteSessionsByUserId(ctx context.Context, userId string, exceptSessionId string) error {
+	ids, err := r.userSessionIds(ctx, userId)
+	if err != nil {
+		return err
+	}
+	del := []string{"DEL"}
+	srem := []string{"SREM", r.userSessionsKey(userId)}
+	for _, id := range ids {
+		if id != exceptSessionId {
+			del = append(del, r.sessionKey(id))
+			srem = append(srem, id)
+		}
+	}
+	if len(del) == 1 {
+		return nil
+	}
+	replies, err := r.client.pipeline(ctx, [][]string{del, srem})
+	if err != nil {
+		return err
+	}
+	return firstReplyError(replies)
+}
+
+// Does nothing and returns zero, since Redis deletes sessions itself when they expire.
+func (r *RedisAuthStore) DeleteExpiredSessions(ctx context.Context, before time.Time, limit int) (int, error) {
+	return 0, ctx.Err()
+}
+
+// Loads the login throttling state for a key, returning an empty state if there is none
+func (r *RedisAuthStore) LoadLoginAttempts(ctx context.Context, key string) (sessions.LoginAttempts, error) {
+	a := sessions.LoginAttempts{Key: key}
+	reply, err := r.client.do(ctx, "GET", r.loginAttemptsKey(key))
+	if errors.Is(err, errNilReply) {
+		return a, nil
+	} else if err != nil {
+		return a, err
+	}
+	var record redisLoginAttempts
+	if err := json.Unmarshal([]byte(reply.(string)), &record); err != nil {
+		return a, err
+	}
+	a.Tokens = record.Tokens
+	a.UpdatedAt = record.UpdatedAt
+	a.Failures = record.Failures
+	a.LockedUntil = record.LockedUntil
+	return a, nil
+}
+
+// Inserts or replaces the login throttling state for a key. It expires LoginAttemptRetention after its last update or
+// the end of its lockout, whichever is later.
+func (r *RedisAuthStore) SaveLoginAttempts(ctx context.Context, a sessions.LoginAttempts) error {
+	record := encodeJSON(redisLoginAttempts{
+		Tokens:      a.Tokens,
+		UpdatedAt:   a.UpdatedAt,
+		Failures:    a.Failures,
+		LockedUntil: a.LockedUntil,
+	})
+	expiresAt := a.UpdatedAt
+	if a.LockedUntil.After(expiresAt) {
+		expiresAt = a.LockedUntil
+	}
+	if expiresAt.IsZero() {
+		expiresAt = clockNow(r.clock)
+	}
+	_, err := r.client.do(ctx, "SET", r.loginAttemptsKey(a.Key), record, "PXAT", unixMilliArg(expiresAt.Add(r.attemptRetention)))
+	return err
+}
+
+// Deletes the login throttling state for a key
+func (r *RedisAuthStore) DeleteLoginAttempts(ctx context.Context, key string) error {
+	_, err := r.client.do(ctx, "DEL", r.loginAttemptsKey(key))
+	return err
+}
@@ -0,0 +1,527 @@
+package auth
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// a stand-in Redis server speaking just enough RESP for RedisAuthStore, with keys expiring like Redis's
+type fakeRedis struct {
+	password string
+
+	mu      sync.Mutex
+	strings map[string]string
+	sets    map[string]map[string]bool
+	expires map[string]time.Time
+	// bumped by every write to a key, which is how WATCH notices changes
+	versions map[string]int64
+	// called with the lock held before EXEC checks the watched keys, to stand in for another client's writes
+	beforeExec func()
+}
+
+// starts a fakeRedis on a local port for the duration of the test and returns its address
+func startFakeRedis(t *testing.T, password string) (*fakeRedis, string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeRedis{
+		password: password,
+		strings:  make(map[string]string),
+		sets:     make(map[string]map[string]bool),
+		expires:  make(map[string]time.Time),
+		versions: make(map[string]int64),
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f, ln.Addr().String()
+}
+
+func (f *fakeRedis) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	authed := f.password == ""
+	// the state of a transaction on this connection: the versions of the watched keys, and the commands queued
+	// after MULTI, which is nil outside of one
+	watched := make(map[string]int64)
+	var queued [][]string
+	for {
+		reply, err := readRESP(r)
+		if err != nil {
+			return
+		}
+		items, _ := reply.([]any)
+		args := make([]string, len(items))
+		for i, item := range items {
+			args[i], _ = item.(string)
+		}
+		if len(args) == 0 {
+			return
+		}
+		var out string
+		switch cmd := strings.ToUpper(args[0]); {
+		case cmd == "AUTH":
+			authed = args[len(args)-1] == f.password
+			out = "+OK\r\n"
+			if !authed {
+				out = "-WRONGPASS invalid username-password pair\r\n"
+			}
+		case !authed:
+			out = "-NOAUTH Authentication required.\r\n"
+		case cmd == "WATCH":
+			f.mu.Lock()
+			for _, key := range args[1:] {
+				watched[key] = f.versions[key]
+			}
+			f.mu.Unlock()
+			out = "+OK\r\n"
+		case cmd == "UNWATCH":
+			watched = make(map[string]int64)
+			out = "+OK\r\n"
+		case cmd == "MULTI":
+			queued = [][]string{}
+			out = "+OK\r\n"
+		case cmd == "EXEC":
+			out = f.execQueued(watched, queued)
+			watched, queued = make(map[string]int64), nil
+		case queued != nil:
+			queued = append(queued, args)
+			out = "+QUEUED\r\n"
+		default:
+			out = f.exec(cmd, args[1:])
+		}
+		if _, err := conn.Write([]byte(out)); err != nil {
+			return
+		}
+	}
+}
+
+func bulk(s string) string {
+	return fmt.Sprintf("$%d\r\n%s\r\n", len(s), s)
+}
+
+const nilBulk = "$-1\r\n"
+
+// drops a key if it has expired. Must be called with the lock held.
+func (f *fakeRedis) expire(key string) {
+	if at, ok := f.expires[key]; ok && !time.Now().Before(at) {
+		delete(f.strings, key)
+		delete(f.sets, key)
+		delete(f.expires, key)
+	}
+}
+
+func (f *fakeRedis) exists(key string) bool {
+	f.expire(key)
+	_, isString := f.strings[key]
+	return isString || f.sets[key] != nil
+}
+
+func (f *fakeRedis) del(key string) bool {
+	existed := f.exists(key)
+	delete(f.strings, key)
+	delete(f.sets, key)
+	delete(f.expires, key)
+	return existed
+}
+
+// runs the commands queued after MULTI, or none of them if a watched key changed since WATCH
+func (f *fakeRedis) execQueued(watched map[string]int64, queued [][]string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.beforeExec != nil {
+		f.beforeExec()
+	}
+	for key, version := range watched {
+		if f.versions[key] != version {
+			return "*-1\r\n"
+		}
+	}
+	out := fmt.Sprintf("*%d\r\n", len(queued))
+	for _, args := range queued {
+		out += f.run(strings.ToUpper(args[0]), args[1:])
+	}
+	return out
+}
+
+func (f *fakeRedis) exec(cmd string, args []string) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.run(cmd, args)
+}
+
+// runs a single command. Must be called with the lock held.
+func (f *fakeRedis) run(cmd string, args []string) string {
+	switch cmd {
+	case "DEL":
+		for _, key := range args {
+			f.versions[key]++
+		}
+	case "SET", "GETDEL", "SADD", "SREM", "PEXPIREAT":
+		f.versions[args[0]]++
+	}
+	switch cmd {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		return "+OK\r\n"
+	case "GET", "GETDEL":
+		f.expire(args[0])
+		value, ok := f.strings[args[0]]
+		if !ok {
+			return nilBulk
+		}
+		if cmd == "GETDEL" {
+			f.del(args[0])
+		}
+		return bulk(value)
+	case "MGET":
+		out := fmt.Sprintf("*%d\r\n", len(args))
+		for _, key := range args {
+			f.expire(key)
+			if value, ok := f.strings[key]; ok {
+				out += bulk(value)
+			} else {
+				out += nilBulk
+			}
+		}
+		return out
+	case "SET":
+		key, value := args[0], args[1]
+		var nx, xx, keepTTL bool
+		var at time.Time
+		for i := 2; i < len(args); i++ {
+			switch strings.ToUpper(args[i]) {
+			case "NX":
+				nx = true
+			case "XX":
+				xx = true
+			case "KEEPTTL":
+				keepTTL = true
+			case "PXAT":
+				ms, _ := strconv.ParseInt(args[i+1], 10, 64)
+				at = time.UnixMilli(ms)
+				i++
+			}
+		}
+		exists := f.exists(key)
+		if (nx && exists) || (xx && !exists) {
+			return nilBulk
+		}
+		ttl, hasTTL := f.expires[key]
+		f.del(key)
+		f.strings[key] = value
+		if !at.IsZero() {
+			f.expires[key] = at
+		} else if keepTTL && hasTTL {
+			f.expires[key] = ttl
+		}
+		return "+OK\r\n"
+	case "EXISTS":
+		n := 0
+		for _, key := range args {
+			if f.exists(key) {
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "DEL":
+		n := 0
+		for _, key := range args {
+			if f.del(key) {
+				n++
+			}
+		}
+		return fmt.Sprintf(":%d\r\n", n)
+	case "SADD":
+		f.expire(args[0])
+		set := f.sets[args[0]]
+		if set == nil {
+			set = make(map[string]bool)
+			f.sets[args[0]] = set
+		}
+		for _, member := range args[1:] {
+			set[member] = true
+		}
+		return fmt.Sprintf(":%d\r\n", len(args)-1)
+	case "SREM":
+		f.expire(args[0])
+		for _, member := range args[1:] {
+			delete(f.sets[args[0]], member)
+		}
+		if len(f.sets[args[0]]) == 0 {
+			f.del(args[0])
+		}
+		return fmt.Sprintf(":%d\r\n", len(args)-1)
+	case "SMEMBERS":
+		f.expire(args[0])
+		out := fmt.Sprintf("*%d\r\n", len(f.sets[args[0]]))
+		for member := range f.sets[args[0]] {
+			out += bulk(member)
+		}
+		return out
+	case "PEXPIREAT":
+		if !f.exists(args[0]) {
+			return ":0\r\n"
+		}
+		ms, _ := strconv.ParseInt(args[1], 10, 64)
+		at := time.UnixMilli(ms)
+		current, hasExpiry := f.expires[args[0]]
+		if len(args) > 2 {
+			switch strings.ToUpper(args[2]) {
+			case "NX":
+				if hasExpiry {
+					return ":0\r\n"
+				}
+			case "GT":
+				if !hasExpiry || !at.After(current) {
+					return ":0\r\n"
+				}
+			}
+		}
+		f.expires[args[0]] = at
+		return ":1\r\n"
+	}
+	return "-ERR unknown command '" + cmd + "'\r\n"
+}
+
+func newTestRedisStore(t *testing.T) (*RedisAuthStore, *fakeRedis) {
+	f, addr := startFakeRedis(t, "secret")
+	cfg := DefaultRedisConfig()
+	cfg.Addr = addr
+	cfg.Password = "secret"
+	cfg.DB = 2
+	store, err := NewRedisAuthStore(t.Context(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store, f
+}
+
+func TestRedisAuthStoreClockAndClose(t *testing.T) {
+	f, addr := startFakeRedis(t, "")
+	cfg := DefaultRedisConfig()
+	cfg.Addr = addr
+	now := time.Now().Add(48 * time.Hour).Truncate(time.Millisecond)
+	cfg.Clock = &fakeClock{now: now}
+	store, err := NewRedisAuthStore(t.Context(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// state that was never timestamped expires a day after now by the store's clock
+	if err := store.SaveLoginAttempts(t.Context(), sessions.LoginAttempts{Key: "user:alice", Failures: 1}); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	at := f.expires[store.loginAttemptsKey("user:alice")]
+	f.mu.Unlock()
+	if want := now.Add(defaultLimiterRetention); !at.Equal(want) {
+		t.Errorf("login attempts expire at %v, want %v", at, want)
+	}
+
+	store.Close()
+	if _, err := store.LoadUserByUserId(t.Context(), "01"); !errors.Is(err, ErrRedisStoreClosed) {
+		t.Errorf("loading a user after Close() returned %v, want %v", err, ErrRedisStoreClosed)
+	}
+}
+
+func TestRedisAuthStoreLoginAttemptRetention(t *testing.T) {
+	f, addr := startFakeRedis(t, "")
+	cfg := DefaultRedisConfig()
+	cfg.Addr = addr
+	cfg.LoginAttemptRetention = 7 * 24 * time.Hour
+	store, err := NewRedisAuthStore(t.Context(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+
+	// a lockout is kept for the configured retention after it ends, not just a day
+	now := time.Now().Truncate(time.Millisecond)
+	a := sessions.LoginAttempts{Key: "user:alice", UpdatedAt: now, Failures: 5, LockedUntil: now.Add(time.Hour)}
+	if err := store.SaveLoginAttempts(t.Context(), a); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	at := f.expires[store.loginAttemptsKey("user:alice")]
+	f.mu.Unlock()
+	if want := a.LockedUntil.Add(cfg.LoginAttemptRetention); !at.Equal(want) {
+		t.Errorf("login attempts expire at %v, want %v", at, want)
+	}
+}
+
+func TestRedisAuthStoreUsers(t *testing.T) {
+	ctx := t.Context()
+	store, _ := newTestRedisStore(t)
+
+	if err := store.SaveUser(ctx, sessions.User{UserId: "01", Username: "alice", HashedPassword: "hash"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveUser(ctx, sessions.User{UserId: "02", Username: "alice"}); !errors.Is(err, sessions.ErrUserExists) {
+		t.Errorf("saving a duplicate username returned %v, want %v", err, sessions.ErrUserExists)
+	}
+	if err := store.SaveUser(ctx, sessions.User{UserId: "01", Username: "bob"}); !errors.Is(err, sessions.ErrUserExists) {
+		t.Errorf("saving a duplicate user id returned %v, want %v", err, sessions.ErrUserExists)
+	}
+	if _, err := store.LoadUserByUsername(ctx, "bob"); !errors.Is(err, sessions.ErrUserNotFound) {
+		t.Errorf("the username of a rejected user was kept: %v", err)
+	}
+
+	if err := store.UpdateUser(ctx, sessions.User{UserId: "01", Username: "alicia", HashedPassword: "new"}); err != nil {
+		t.Fatal(err)
+	}
+	u, err := store.LoadUserByUsername(ctx, "alicia")
+	if err != nil || u.UserId != "01" || u.HashedPassword != "new" {
+		t.Errorf("LoadUserByUsername() = %+v, %v", u, err)
+	}
+	if _, err := store.LoadUserByUsername(ctx, "alice"); !errors.Is(err, sessions.ErrUserNotFound) {
+		t.Errorf("loading a user by their old username returned %v, want %v", err, sessions.ErrUserNotFound)
+	}
+	if err := store.UpdateUser(ctx, sessions.User{UserId: "03", Username: "carol"}); !errors.Is(err, sessions.ErrUserNotFound) {
+		t.Errorf("updating a missing user returned %v, want %v", err, sessions.ErrUserNotFound)
+	}
+}
+
+func TestRedisAuthStoreSessions(t *testing.T) {
+	ctx := t.Context()
+	store, f := newTestRedisStore(t)
+	store.SaveUser(ctx, sessions.User{UserId: "01", Username: "alice"})
+	now := time.Now().Truncate(time.Second)
+	for _, id := range []string{"s1", "s2", "s3"} {
+		s := sessions.Session{Id: sessions.SessionId(id), UserId: "01", CreatedAt: now, ExpiresAt: now.Add(time.Hour), UserAgent: "curl"}
+		if err := store.SaveSession(ctx, s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := store.SaveSession(ctx, sessions.Session{Id: "s1", UserId: "01", ExpiresAt: now.Add(time.Hour)}); !errors.Is(err, sessions.ErrSessionExists) {
+		t.Errorf("saving a duplicate session returned %v, want %v", err, sessions.ErrSessionExists)
+	}
+
+	// the session key expires with the session
+	f.mu.Lock()
+	at := f.expires[store.sessionKey("s1")]
+	f.mu.Unlock()
+	if !at.Equal(now.Add(time.Hour)) {
+		t.Errorf("session key expires at %v, want %v", at, now.Add(time.Hour))
+	}
+
+	s, err := store.LoadSessionById(ctx, "s1")
+	if err != nil || s.UserId != "01" || s.UserAgent != "curl" || !s.ExpiresAt.Equal(now.Add(time.Hour)) {
+		t.Errorf("LoadSessionById() = %+v, %v", s, err)
+	}
+	s.ExpiresAt = now.Add(2 * time.Hour)
+	if err := store.UpdateSession(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+	f.mu.Lock()
+	at = f.expires[store.sessionKey("s1")]
+	setAt := f.expires[store.userSessionsKey("01")]
+	f.mu.Unlock()
+	if !at.Equal(now.Add(2*time.Hour)) || !setAt.Equal(now.Add(2*time.Hour)) {
+		t.Errorf("after renewal the session expires at %v and the user's set at %v, want %v", at, setAt, now.Add(2*time.Hour))
+	}
+
+	// an expired session disappears from the store and from the user's list
+	f.mu.Lock()
+	f.expires[store.sessionKey("s3")] = time.Now().Add(-time.Second)
+	f.mu.Unlock()
+	if _, err := store.LoadSessionById(ctx, "s3"); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("loading an expired session returned %v, want %v", err, sessions.ErrSessionNotFound)
+	}
+	list, err := store.ListSessionsByUserId(ctx, "01")
+	if err != nil || len(list) != 2 {
+		t.Errorf("ListSessionsByUserId() returned %d sessions, %v, want 2", len(list), err)
+	}
+
+	if err := store.DeleteSessionsByUserId(ctx, "01", "s1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadSessionById(ctx, "s2"); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("loading a revoked session returned %v, want %v", err, sessions.ErrSessionNotFound)
+	}
+	if err := store.DeleteSessionById(ctx, "s2"); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("deleting a missing session returned %v, want %v", err, sessions.ErrSessionNotFound)
+	}
+
+	if err := store.DeleteUserByUserId(ctx, "01"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.LoadSessionById(ctx, "s1"); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("loading a deleted user's session returned %v, want %v", err, sessions.ErrSessionNotFound)
+	}
+	f.mu.Lock()
+	left := len(f.strings) + len(f.sets)
+	f.mu.Unlock()
+	if left != 0 {
+		t.Errorf("%d keys are left after deleting the only user", left)
+	}
+}
+
+func TestRedisAuthStoreUpdateSessionData(t *testing.T) {
+	ctx := t.Context()
+	store, f := newTestRedisStore(t)
+	now := time.Now().Truncate(time.Second)
+	s := sessions.Session{Id: "s1", UserId: "01", CreatedAt: now, ExpiresAt: now.Add(time.Hour), LastSeenAt: now}
+	if err := store.SaveSession(ctx, s); err != nil {
+		t.Fatal(err)
+	}
+
+	// another request renews the session between reading it and writing the data back
+	renewed := s
+	renewed.ExpiresAt = now.Add(2 * time.Hour)
+	renewed.LastSeenAt = now.Add(time.Minute)
+	key := store.sessionKey("s1")
+	f.beforeExec = func() {
+		f.beforeExec = nil
+		f.run("SET", []string{key, redisSessionRecord(renewed), "PXAT", unixMilliArg(renewed.ExpiresAt)})
+	}
+	data := sessions.SessionData{"cart": []byte(`["apple"]`)}
+	if err := store.UpdateSessionData(ctx, "s1", data); err != nil {
+		t.Fatalf("UpdateSessionData() error = %v", err)
+	}
+	got, err := store.LoadSessionById(ctx, "s1")
+	if err != nil || !got.ExpiresAt.Equal(renewed.ExpiresAt) || !got.LastSeenAt.Equal(renewed.LastSeenAt) || !got.Data.Equal(data) {
+		t.Errorf("after UpdateSessionData() the session is %+v, %v, want the renewal kept along with the new data", got, err)
+	}
+	f.mu.Lock()
+	at := f.expires[key]
+	f.mu.Unlock()
+	if !at.Equal(renewed.ExpiresAt) {
+		t.Errorf("session key expires at %v, want the renewed %v", at, renewed.ExpiresAt)
+	}
+
+	if err := store.UpdateSessionData(ctx, "missing", data); !errors.Is(err, sessions.ErrSessionNotFound) {
+		t.Errorf("UpdateSessionData() of a missing session returned %v, want %v", err, sessions.ErrSessionNotFound)
+	}
+}
+
+func TestRedisAuthStoreRejectsWrongPassword(t *testing.T) {
+	_, addr := startFakeRedis(t, "secret")
+	cfg := DefaultRedisConfig()
+	cfg.Addr = addr
+	cfg.Password = "wrong"
+	var redisErr RedisError
+	if _, err := NewRedisAuthStore(t.Context(), cfg); !errors.As(err, &redisErr) {
+		t.Errorf("connecting with the wrong password returned %v, want a RedisError", err)
+	}
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"mime"
+	"net/http"
+)
+
+// the largest request body the auth handlers will read
+const maxRequestBodyBytes = 1 << 20
+
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// The body expected by RegisterHandler and LoginHandler, either as a JSON object or as form fields with the same
+// names.
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// Decodes the request body into v based on the request's Content-Type. JSON bodies (and bodies without a
+// Content-Type) are unmarshalled into v, while for application/x-www-form-urlencoded and multipart/form-data bodies
+// the fields callback is given a getter for the posted form values.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v any, fields func(get func(string) string)) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodyBytes)
+
+	mediaType := "application/json"
+	if contentType := r.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return errUnsupportedMediaType
+		}
+	}
+
+	switch mediaType {
+	case "application/json":
+		return json.NewDecoder(r.Body).Decode(v)
+	case "application/x-www-form-urlencoded":
+		if err := r.ParseForm(); err != nil {
+			return err
+		}
+		fields(r.PostForm.Get)
+		return nil
+	case "multipart/form-data":
+		if err := r.ParseMultipartForm(maxRequestBodyBytes); err != nil {
+			return err
+		}
+		fields(r.PostForm.Get)
+		return nil
+	}
+	return errUnsupportedMediaType
+}
+
+// Decodes the username and password from a request, returning the error to send to the client if the body is
+// malformed or either field is missing.
+func decodeCredentials(w http.ResponseWriter, r *http.Request) (Credentials, *apiError) {
+	var c Credentials
+	err := decodeRequest(w, r, &c, func(get func(string) string) {
+		c.Username = get("username")
+		c.Password = get("password")
+	})
+	if err != nil {
+		return c, requestDecodeError(err)
+	}
+	if c.Username == "" || c.Password == "" {
+		return c, &apiError{
+			Status:  http.StatusBadRequest,
+			Code:    ErrCodeInvalidRequest,
+			Message: "A username and password are required",
+		}
+	}
+	return c, nil
+}
+
+// maps an error from decodeRequest to the response sent to the client
+func requestDecodeError(err error) *apiError {
+	if errors.Is(err, errUnsupportedMediaType) {
+		return &apiError{
+			Status:  http.StatusUnsupportedMediaType,
+			Code:    ErrCodeUnsupportedMediaType,
+			Message: "The request body must be JSON or form encoded",
+		}
+	}
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		return &apiError{
+			Status:  http.StatusRequestEntityTooLarge,
+			Code:    ErrCodeInvalidRequest,
+			Message: "The request body is too large",
+		}
+	}
+	return &apiError{
+		Status:  http.StatusBadRequest,
+		Code:    ErrCodeInvalidRequest,
+		Message: "The request body could not be parsed",
+	}
+}
@@ -0,0 +1,337 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// An error reply from a Redis server, such as "WRONGTYPE Operation against a key holding the wrong kind of value".
+type RedisError string
+
+func (e RedisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// errNilReply is the value of a nil bulk string or array reply, which Redis sends for missing keys and for SET NX/XX
+// when the key was not set.
+var errNilReply = errors.New("redis: nil reply")
+
+// A minimal client for the Redis serialization protocol (RESP2), just enough for RedisAuthStore. It keeps a small
+// pool of connections and sends commands as arrays of bulk strings.
+type respClient struct {
+	cfg RedisConfig
+
+	mu     sync.Mutex
+	idle   []*respConn
+	closed bool
+	slots  chan struct{} // limits the number of open connections to PoolSize
+}
+
+// A connection to the server with its buffered reader and writer.
+type respConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+func newRESPClient(cfg RedisConfig) *respClient {
+	size := cfg.PoolSize
+	if size <= 0 {
+		size = 1
+	}
+	return &respClient{cfg: cfg, slots: make(chan struct{}, size)}
+}
+
+// Sends a single command and returns its reply. Error replies are returned as a RedisError.
+func (c *respClient) do(ctx context.Context, args ...string) (any, error) {
+	replies, err := c.pipeline(ctx, [][]string{args})
+	if err != nil {
+		return nil, err
+	}
+	if err, ok := replies[0].(error); ok {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// Sends several commands in one round trip and returns their replies in order. Error replies are returned in place
+// as a RedisError rather than failing the whole pipeline, since the other commands still ran.
+func (c *respClient) pipeline(ctx context.Context, cmds [][]string) ([]any, error) {
+	var replies []any
+	err := c.withConn(ctx, func(conn *respConn) error {
+		var err error
+		replies, err = conn.roundTrip(cmds)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return replies, nil
+}
+
+// how many times transaction tries again when a watched key changes before EXEC
+const maxTransactionTries = 5
+
+// Runs a check-and-set transaction on a single connection: WATCHes keys, sends reads and passes their replies to
+// build, which returns the commands to run between MULTI and EXEC. If a watched key changes before EXEC the whole
+// thing is tried again, up to maxTransactionTries times. Returns the replies to the queued commands, or the error
+// build returned, in which case nothing is run.
+func (c *respClient) transaction(ctx context.Context, keys []string, reads [][]string, build func(replies []any) ([][]string, error)) ([]any, error) {
+	watch := append([][]string{append([]string{"WATCH"}, keys...)}, reads...)
+	var result []any
+	var txErr error
+	err := c.withConn(ctx, func(conn *respConn) error {
+		for range maxTransactionTries {
+			replies, err := conn.roundTrip(watch)
+			if err != nil {
+				return err
+			}
+			if watchErr, ok := replies[0].(error); ok {
+				txErr = watchErr
+				return nil
+			}
+			cmds, err := build(replies[1:])
+			if err != nil || len(cmds) == 0 {
+				txErr = err
+				_, err := conn.roundTrip([][]string{{"UNWATCH"}})
+				return err
+			}
+			replies, err = conn.roundTrip(append(append([][]string{{"MULTI"}}, cmds...), []string{"EXEC"}))
+			if err != nil {
+				return err
+			}
+			switch exec := replies[len(replies)-1].(type) {
+			case []any:
+				result = exec
+				return nil
+			case error:
+				// a nil reply means a watched key changed and nothing ran
+				if !errors.Is(exec, errNilReply) {
+					txErr = exec
+					return nil
+				}
+			}
+		}
+		txErr = ErrRedisTransactionConflict
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, txErr
+}
+
+// Runs fn with a connection of its own, which a cancelled context interrupts. fn returns only errors that may leave
+// the connection halfway through a reply, after which it is closed rather than reused.
+func (c *respClient) withConn(ctx context.Context, fn func(conn *respConn) error) error {
+	conn, err := c.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	// a cancelled context interrupts a blocked read or write by moving the deadline into the past
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	} else {
+		conn.SetDeadline(time.Time{})
+	}
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(time.Unix(1, 0))
+	})
+
+	err = fn(conn)
+	if !stop() || err != nil {
+		// the connection may be left halfway through a reply
+		conn.Close()
+		c.release(nil)
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return ctxErr
+		}
+		return err
+	}
+	c.release(conn)
+	return nil
+}
+
+func (conn *respConn) roundTrip(cmds [][]string) ([]any, error) {
+	for _, args := range cmds {
+		fmt.Fprintf(conn.w, "*%d\r\n", len(args))
+		for _, arg := range args {
+			fmt.Fprintf(conn.w, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := conn.w.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]any, len(cmds))
+	for i := range cmds {
+		reply, err := readRESP(conn.r)
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// Reads one reply: a string for simple and bulk strings, an int64 for integers, a []any for arrays, errNilReply for
+// nil bulk strings and arrays, and a RedisError for errors.
+func readRESP(r *bufio.Reader) (any, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, fmt.Errorf("redis: malformed reply %q", line)
+	}
+	kind, body := line[0], line[1:len(line)-2]
+	switch kind {
+	case '+':
+		return body, nil
+	case '-':
+		return RedisError(body), nil
+	case ':':
+		return strconv.ParseInt(body, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed bulk string length %q", body)
+		}
+		if n < 0 {
+			return errNilReply, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(body)
+		if err != nil {
+			return nil, fmt.Errorf("redis: malformed array length %q", body)
+		}
+		if n < 0 {
+			return errNilReply, nil
+		}
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = readRESP(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", kind)
+}
+
+// Returns an idle connection or dials a new one, waiting for a free slot if PoolSize connections are in use. Returns
+// ErrRedisStoreClosed once the client has been closed.
+func (c *respClient) get(ctx context.Context) (*respConn, error) {
+	select {
+	case c.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		<-c.slots
+		return nil, ErrRedisStoreClosed
+	}
+	if n := len(c.idle); n > 0 {
+		conn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return conn, nil
+	}
+	c.mu.Unlock()
+
+	conn, err := c.dial(ctx)
+	if err != nil {
+		<-c.slots
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Returns a connection to the pool, or just frees its slot if conn is nil because it was closed.
+func (c *respClient) release(conn *respConn) {
+	if conn != nil {
+		c.mu.Lock()
+		if c.closed {
+			conn.Close()
+		} else {
+			c.idle = append(c.idle, conn)
+		}
+		c.mu.Unlock()
+	}
+	<-c.slots
+}
+
+// Dials the server, authenticates and selects the database.
+func (c *respClient) dial(ctx context.Context) (*respConn, error) {
+	dialer := &net.Dialer{Timeout: c.cfg.DialTimeout}
+	var netConn net.Conn
+	var err error
+	if c.cfg.TLS != nil {
+		netConn, err = (&tls.Dialer{NetDialer: dialer, Config: c.cfg.TLS}).DialContext(ctx, "tcp", c.cfg.Addr)
+	} else {
+		netConn, err = dialer.DialContext(ctx, "tcp", c.cfg.Addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn := &respConn{Conn: netConn, r: bufio.NewReader(netConn), w: bufio.NewWriter(netConn)}
+
+	var setup [][]string
+	if c.cfg.Password != "" {
+		if c.cfg.Username != "" {
+			setup = append(setup, []string{"AUTH", c.cfg.Username, c.cfg.Password})
+		} else {
+			setup = append(setup, []string{"AUTH", c.cfg.Password})
+		}
+	}
+	if c.cfg.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.cfg.DB)})
+	}
+	if len(setup) == 0 {
+		return conn, nil
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	replies, err := conn.roundTrip(setup)
+	if err == nil {
+		for _, reply := range replies {
+			if replyErr, ok := reply.(error); ok {
+				err = replyErr
+				break
+			}
+		}
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Closes the idle connections. Connections in use are closed when they are released.
+func (c *respClient) close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, conn := range c.idle {
+		conn.Close()
+	}
+	c.idle = nil
+	c.closed = true
+	return nil
+}
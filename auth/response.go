@@ -0,0 +1,82 @@
+package auth
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// Machine-readable error codes returned in the "code" field of error responses.
+const (
+	ErrCodeInvalidRequest       = "invalid_request"
+	ErrCodeUnsupportedMediaType = "unsupported_media_type"
+	ErrCodeUsernameTaken        = "username_taken"
+	ErrCodeValidationFailed     = "validation_failed"
+	ErrCodeInvalidCredentials   = "invalid_credentials"
+	ErrCodeRateLimited          = "rate_limited"
+	ErrCodeNotAuthenticated     = "not_authenticated"
+	ErrCodeInvalidSession       = "invalid_session"
+	ErrCodeSessionExpired       = "session_expired"
+	ErrCodeSessionNotFound      = "session_not_found"
+	ErrCodeInvalidCSRFToken     = "invalid_csrf_token"
+	ErrCodeInternal             = "internal_error"
+)
+
+// The JSON body of every error response sent by the auth handlers and middleware:
+//
+// { "error" : { "code" : "invalid_credentials", "message" : "Invalid username or password" } }
+//
+// Validation failures also list the problem with each field:
+//
+// { "error" : { "code" : "validation_failed", "message" : "...", "fields" : [ { "field" : "password", "code" : "too_short", "message" : "..." } ] } }
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code    string       `json:"code"`
+	Message string       `json:"message"`
+	Fields  []FieldError `json:"fields,omitempty"`
+}
+
+// The JSON body sent after a successful registration or login.
+type UserResponse struct {
+	UserId   string `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// The JSON body sent by handlers that have nothing else to report.
+type MessageResponse struct {
+	Message string `json:"message"`
+}
+
+// an error response waiting to be written
+type apiError struct {
+	Status  int
+	Code    string
+	Message string
+	Fields  []FieldError
+}
+
+var internalError = &apiError{
+	Status:  http.StatusInternalServerError,
+	Code:    ErrCodeInternal,
+	Message: "Internal server error",
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Error writing response: %s", err)
+	}
+}
+
+func writeError(w http.ResponseWriter, e *apiError) {
+	writeJSON(w, e.Status, ErrorResponse{Error: ErrorBody{Code: e.Code, Message: e.Message, Fields: e.Fields}})
+}
+
+// writes an error response with the given status, code and message
+func writeErrorCode(w http.ResponseWriter, status int, code string, message string) {
+	writeError(w, &apiError{Status: status, Code: code, Message: message})
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// The key of a value of type T in the data of a request's session. Values are stored as JSON, so T has to survive
+// a round trip through encoding/json.
+//
+//	var cartKey = auth.SessionKey[Cart]("cart")
+//
+//	cart, _ := cartKey.Get(r.Context())
+//	cart.Items = append(cart.Items, item)
+//	err := cartKey.Set(r.Context(), cart)
+//
+// Changes are saved by Authmiddleware or GuestMiddleware once the handler returns, and only if there were any.
+// Concurrent requests with the same session each save the data as they left it, so the last one to finish wins, but
+// saving the data never changes the session's expiry or last seen time.
+type SessionKey[T any] string
+
+// Returns the value stored under the key in the session of the request, and false if there is none or the request
+// did not pass through Authmiddleware or GuestMiddleware. A stored value that can't be decoded into a T is logged and reported as
+// missing.
+func (k SessionKey[T]) Get(ctx context.Context) (T, bool) {
+	var value T
+	rs := requestSessionFromContext(ctx)
+	if rs == nil {
+		return value, false
+	}
+	rs.mu.Lock()
+	raw, ok := rs.session.Data[string(k)]
+	rs.mu.Unlock()
+	if !ok {
+		return value, false
+	}
+	if err := json.Unmarshal(raw, &value); err != nil {
+		log.Printf("Error decoding session value %s: %s", string(k), err)
+		return value, false
+	}
+	return value, true
+}
+
+// Stores a value under the key in the session of the request. Returns ErrNoSessionInContext if the request did not
+// pass through Authmiddleware or GuestMiddleware.
+func (k SessionKey[T]) Set(ctx context.Context, value T) error {
+	rs := requestSessionFromContext(ctx)
+	if rs == nil {
+		return ErrNoSessionInContext
+	}
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if old, ok := rs.session.Data[string(k)]; ok && bytes.Equal(old, raw) {
+		return nil
+	}
+	if rs.session.Data == nil {
+		rs.session.Data = make(sessions.SessionData)
+	}
+	rs.session.Data[string(k)] = raw
+	rs.dirty = true
+	return nil
+}
+
+// Removes the value stored under the key from the session of the request. Returns ErrNoSessionInContext if the
+// request did not pass through Authmiddleware or GuestMiddleware.
+func (k SessionKey[T]) Delete(ctx context.Context) error {
+	rs := requestSessionFromContext(ctx)
+	if rs == nil {
+		return ErrNoSessionInContext
+	}
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if _, ok := rs.session.Data[string(k)]; ok {
+		delete(rs.session.Data, string(k))
+		rs.dirty = true
+	}
+	return nil
+}
+
+// Writes the data of a request's session back to the store if a handler changed it, leaving the expiry and last seen
+// time to whichever request renewed the session last. A session that was deleted while the request was handled, for
+// example by logging out, is left deleted, and so is a guest session that was replaced by logging in.
+func (ac *AuthContext) saveSessionData(ctx context.Context, rs *requestSession) {
+	rs.mu.Lock()
+	if !rs.dirty || rs.replaced {
+		rs.mu.Unlock()
+		return
+	}
+	s := rs.session
+	data := s.Data.Clone()
+	rs.dirty = false
+	rs.mu.Unlock()
+
+	// the client may be gone by now, but the changes were made on its behalf and should be kept
+	err := sessions.UpdateSessionData(context.WithoutCancel(ctx), ac.Sessions, string(s.Id), data)
+	if err != nil && !errors.Is(err, sessions.ErrSessionNotFound) {
+		log.Printf("Error saving data of session of user %s: %s", s.UserId, err)
+	}
+}
@@ -0,0 +1,19 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+)
+
+// the SQLSTATE Postgres reports for a unique_violation
+const postgresUniqueViolation = "23505"
+
+// Reports whether err is a SQL driver's error for a violated unique or primary key constraint. It recognizes the
+// SQLState method of the pgx and lib/pq errors and the message SQLite uses, so the stores don't depend on a driver.
+func isUniqueViolation(err error) bool {
+	var stateErr interface{ SQLState() string }
+	if errors.As(err, &stateErr) {
+		return stateErr.SQLState() == postgresUniqueViolation
+	}
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed")
+}
@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+	"strings"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// Returns the digest a SQL store keeps in place of a session id, so that reading the sessions table, or a backup of
+// it, doesn't give away live session cookies. Ids made by sessions.SessionIdFromDigest name their digest already.
+func sessionDigest(id string) string {
+	return sessions.SessionId(id).Digest()
+}
+
+// Returns the id of a session as listed by a SQL store, which knows only its digest.
+func storedSessionId(digest string) sessions.SessionId {
+	return sessions.SessionIdFromDigest(digest)
+}
+
+// The SQLite migration that started hashing session ids can't compute SHA-256 in SQL, so it marks the plaintext ids
+// of existing sessions with this prefix instead. The SQLite store still finds them by their plaintext id until
+// MigrateSQLite or HashLegacySessionIds replaces them with digests.
+const sqliteLegacySessionIdPrefix = "plain:"
+
+// returns the key a session from before session ids were hashed is stored under in SQLite
+func sqliteLegacySessionKey(id string) string {
+	return sqliteLegacySessionIdPrefix + id
+}
+
+// returns the id of a session as listed by the SQLite store from the key it is stored under
+func sqliteStoredSessionId(key string) sessions.SessionId {
+	if id, ok := strings.CutPrefix(key, sqliteLegacySessionIdPrefix); ok {
+		return sessions.SessionId(id)
+	}
+	return storedSessionId(key)
+}
+
+// replaces the plaintext ids of sessions marked by sqliteLegacySessionIdPrefix with their digests, and returns how many
+// were replaced
+func hashSQLiteLegacySessionIds(ctx context.Context, db execQueryer, names *sqlNames) (int, error) {
+	rows, err := db.QueryContext(ctx, names.expand(`SELECT id FROM {{sessions}} WHERE id LIKE ?`), sqliteLegacySessionIdPrefix+"%")
+	if err != nil {
+		return 0, err
+	}
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, key := range keys {
+		digest := sessionDigest(strings.TrimPrefix(key, sqliteLegacySessionIdPrefix))
+		if _, err := db.ExecContext(ctx, names.expand(`UPDATE {{sessions}} SET id = ? WHERE id = ?`), digest, key); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
@@ -11,62 +11,52 @@ import (
 
 type SQLiteAuthStore struct {
 	DB *sql.DB
-}
 
-// Returns a new SQLite AuthStore and creates the necessary user and sessions tables if they don't exist
-func NewSQLiteStore(db *sql.DB) (*SQLiteAuthStore, error) {
+	names *sqlNames
+}
 
-	// set up session table
-	newSessionTableQuery := `
-	CREATE TABLE IF NOT EXISTS sessions (
-	id TEXT PRIMARY KEY,
-	user_id TEXT NOT NULL,
-	expires_at TIMESTAMP NOT NULL
-	);
-	`
-	_, err := db.Exec(newSessionTableQuery)
+// Returns a new SQLite AuthStore and brings the database schema up to date by applying any migrations that have not
+// been applied yet, unless WithoutAutoMigrate is given.
+func NewSQLiteStore(db *sql.DB, opts ...StoreOption) (*SQLiteAuthStore, error) {
+	cfg, err := newStoreConfig(opts)
 	if err != nil {
 		return nil, err
 	}
-
-	// set up session table
-	newUserTableQuery := `
-	CREATE TABLE IF NOT EXISTS users (
-	user_id TEXT PRIMARY KEY,
-	username TEXT NOT NULL,
-	hashed_password TEXT NOT NULL
-	);
-	`
-	_, err = db.Exec(newUserTableQuery)
-	if err != nil {
-		return nil, err
+	if cfg.schema != "" {
+		return nil, ErrSchemaNotSupported
+	}
+	if cfg.autoMigrate {
+		if err := MigrateSQLite(context.Background(), db, opts...); err != nil {
+			return nil, err
+		}
 	}
-
 	return &SQLiteAuthStore{
-		DB: db,
+		DB:    db,
+		names: newSQLNames(cfg),
 	}, nil
 }
 
-func (s *SQLiteAuthStore) SaveUser(u sessions.User) error {
-	existingUser, err := s.LoadUserByUserId(u.UserId, context.Background())
-	if existingUser.HashedPassword != "" {
-		return errors.New("User already exists, cannot save user")
-	}
+// fills in the configured table names of a query
+func (s *SQLiteAuthStore) query(q string) string {
+	return s.names.expand(q)
+}
+
+func (s *SQLiteAuthStore) SaveUser(ctx context.Context, u sessions.User) error {
 	newUserQuery := `
-		INSERT INTO users (user_id, hashed_password, username)
+		INSERT INTO {{users}} (user_id, hashed_password, username)
 		VALUES (?, ?, ?)
 		`
-	_, err = s.DB.Exec(newUserQuery, u.UserId, u.HashedPassword, u.Username)
-	if err != nil {
-		return err
+	_, err := s.DB.ExecContext(ctx, s.query(newUserQuery), u.UserId, u.HashedPassword, u.Username)
+	if isUniqueViolation(err) {
+		return sessions.ErrUserExists
 	}
-	return nil
+	return err
 }
 
-func (s *SQLiteAuthStore) LoadUserByUserId(id string, ctx context.Context) (sessions.User, error) {
+func (s *SQLiteAuthStore) LoadUserByUserId(ctx context.Context, id string) (sessions.User, error) {
 	var u sessions.User
 	u.UserId = id
-	err := s.DB.QueryRowContext(ctx, "SELECT hashed_password, username FROM users WHERE user_id = ?", id).Scan(&u.HashedPassword, &u.Username)
+	err := s.DB.QueryRowContext(ctx, s.query("SELECT hashed_password, username FROM {{users}} WHERE user_id = ?"), id).Scan(&u.HashedPassword, &u.Username)
 	if errors.Is(sql.ErrNoRows, err) {
 		return u, sessions.ErrUserNotFound
 	} else if err != nil {
@@ -75,10 +65,10 @@ func (s *SQLiteAuthStore) LoadUserByUserId(id string, ctx context.Context) (sess
 	return u, nil
 }
 
-func (s *SQLiteAuthStore) LoadUserByUsername(username string, ctx context.Context) (sessions.User, error) {
+func (s *SQLiteAuthStore) LoadUserByUsername(ctx context.Context, username string) (sessions.User, error) {
 	var u sessions.User
 	u.Username = username
-	err := s.DB.QueryRowContext(ctx, "SELECT hashed_password, user_id FROM users WHERE username = ?", username).Scan(&u.HashedPassword, &u.UserId)
+	err := s.DB.QueryRowContext(ctx, s.query("SELECT hashed_password, user_id FROM {{users}} WHERE username = ?"), username).Scan(&u.HashedPassword, &u.UserId)
 	if errors.Is(sql.ErrNoRows, err) {
 		return u, sessions.ErrUserNotFound
 	} else if err != nil {
@@ -87,25 +77,109 @@ func (s *SQLiteAuthStore) LoadUserByUsername(username string, ctx context.Contex
 	return u, nil
 }
 
-func (s *SQLiteAuthStore) SaveSession(session sessions.Session) error {
-	newSessionQuery := `
-		INSERT INTO sessions (id, user_id, expires_at)
-		VALUES (?, ?, ?)
-		`
-	_, err := s.DB.Exec(newSessionQuery, session.Id, session.UserId, session.ExpiresAt)
+// Updates the username and hashed password of an existing user
+func (s *SQLiteAuthStore) UpdateUser(ctx context.Context, u sessions.User) error {
+	updateUserQuery := `
+	UPDATE {{users}}
+	SET username = ?, hashed_password = ?
+	WHERE user_id = ?
+	`
+	result, err := s.DB.ExecContext(ctx, s.query(updateUserQuery), u.Username, u.HashedPassword, u.UserId)
+	if isUniqueViolation(err) {
+		return sessions.ErrUserExists
+	} else if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
+
+	if affected == 0 {
+		return sessions.ErrUserNotFound
+	}
 	return nil
 }
 
-func (s *SQLiteAuthStore) DeleteSessionById(id string) error {
+// Deletes a user and all of their sessions in a single transaction
+func (s *SQLiteAuthStore) DeleteUserByUserId(ctx context.Context, id string) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, s.query(`DELETE FROM {{sessions}} WHERE user_id = ?`), id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, s.query(`DELETE FROM {{users}} WHERE user_id = ?`), id)
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sessions.ErrUserNotFound
+	}
+	return tx.Commit()
+}
+
+// Saves a new session under the digest of its id
+func (s *SQLiteAuthStore) SaveSession(ctx context.Context, session sessions.Session) error {
+	return s.saveSession(ctx, s.DB, session)
+}
+
+// Deletes the session with the old id, if it still exists, and saves the new session in a single transaction
+func (s *SQLiteAuthStore) ReplaceSession(ctx context.Context, oldSessionId string, session sessions.Session) error {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, s.query(`DELETE FROM {{sessions}} WHERE id IN (?, ?)`), sessionDigest(oldSessionId), sqliteLegacySessionKey(oldSessionId))
+	if err != nil {
+		return err
+	}
+	if err := s.saveSession(ctx, tx, session); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// inserts a session with the given connection or transaction
+func (s *SQLiteAuthStore) saveSession(ctx context.Context, db execQueryer, session sessions.Session) error {
+	data, err := session.Data.Encode()
+	if err != nil {
+		return err
+	}
+	newSessionQuery := `
+		INSERT INTO {{sessions}} (id, user_id, created_at, expires_at, last_seen_at, user_agent, ip_address, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`
+	_, err = db.ExecContext(ctx, s.query(newSessionQuery), sessionDigest(string(session.Id)), session.UserId, session.CreatedAt.Unix(), session.ExpiresAt.Unix(),
+		session.LastSeenAt.Unix(), session.UserAgent, session.IPAddress, data)
+	if isUniqueViolation(err) {
+		return sessions.ErrSessionExists
+	}
+	return err
+}
+
+// Deletes a session by its id, or by the id it was listed under
+func (s *SQLiteAuthStore) DeleteSessionById(ctx context.Context, id string) error {
 
 	deleteSessionQuery := `
-	DELETE FROM sessions
-	WHERE id = ?
+	DELETE FROM {{sessions}}
+	WHERE id IN (?, ?)
 	`
-	result, err := s.DB.Exec(deleteSessionQuery, id)
+	result, err := s.DB.ExecContext(ctx, s.query(deleteSessionQuery), sessionDigest(id), sqliteLegacySessionKey(id))
 	if err != nil {
 		return err
 	}
@@ -116,19 +190,232 @@ func (s *SQLiteAuthStore) DeleteSessionById(id string) error {
 	}
 
 	if affected == 0 {
-		return errors.New("Session not found")
+		return sessions.ErrSessionNotFound
 	}
 	return nil
 }
 
-func (s *SQLiteAuthStore) LoadSessionById(id string, ctx context.Context) (sessions.Session, error) {
+// Loads a session by its id, which is looked up by its digest
+func (s *SQLiteAuthStore) LoadSessionById(ctx context.Context, id string) (sessions.Session, error) {
 	var session sessions.Session
 	session.Id = sessions.SessionId(id)
-	var storedUserID string
-	var expiresAt time.Time
-	query := `SELECT user_id, expires_at FROM sessions WHERE id = ?`
-	err := s.DB.QueryRowContext(ctx, query, id).Scan(&storedUserID, &expiresAt)
-	session.ExpiresAt = expiresAt
+	if session.Id.IsDigest() {
+		return session, sessions.ErrSessionNotFound
+	}
+	var storedUserID, data string
+	var createdAtUnix, expiresAtUnix, lastSeenAtUnix int64
+	query := `SELECT user_id, created_at, expires_at, last_seen_at, user_agent, ip_address, data FROM {{sessions}} WHERE id IN (?, ?)`
+	err := s.DB.QueryRowContext(ctx, s.query(query), sessionDigest(id), sqliteLegacySessionKey(id)).Scan(&storedUserID, &createdAtUnix, &expiresAtUnix, &lastSeenAtUnix,
+		&session.UserAgent, &session.IPAddress, &data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return session, sessions.ErrSessionNotFound
+	} else if err != nil {
+		return session, err
+	}
+	session.Data, err = sessions.DecodeSessionData(data)
+	if err != nil {
+		return session, err
+	}
+	session.CreatedAt = time.Unix(createdAtUnix, 0)
+	session.ExpiresAt = time.Unix(expiresAtUnix, 0)
+	session.LastSeenAt = time.Unix(lastSeenAtUnix, 0)
 	session.UserId = storedUserID
 	return session, err
 }
+
+// Returns all sessions belonging to a user. Their ids are made by sessions.SessionIdFromDigest, except for sessions
+// whose plaintext id has not been hashed yet.
+func (s *SQLiteAuthStore) ListSessionsByUserId(ctx context.Context, userId string) ([]sessions.Session, error) {
+	query := `
+	SELECT id, created_at, expires_at, last_seen_at, user_agent, ip_address, data
+	FROM {{sessions}}
+	WHERE user_id = ?
+	ORDER BY created_at
+	`
+	rows, err := s.DB.QueryContext(ctx, s.query(query), userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []sessions.Session
+	for rows.Next() {
+		session := sessions.Session{UserId: userId}
+		var key, data string
+		var createdAtUnix, expiresAtUnix, lastSeenAtUnix int64
+		err := rows.Scan(&key, &createdAtUnix, &expiresAtUnix, &lastSeenAtUnix, &session.UserAgent, &session.IPAddress, &data)
+		if err != nil {
+			return nil, err
+		}
+		session.Data, err = sessions.DecodeSessionData(data)
+		if err != nil {
+			return nil, err
+		}
+		session.Id = sqliteStoredSessionId(key)
+		session.CreatedAt = time.Unix(createdAtUnix, 0)
+		session.ExpiresAt = time.Unix(expiresAtUnix, 0)
+		session.LastSeenAt = time.Unix(lastSeenAtUnix, 0)
+		list = append(list, session)
+	}
+	return list, rows.Err()
+}
+
+// Deletes all sessions belonging to a user except the given one
+func (s *SQLiteAuthStore) DeleteSessionsByUserId(ctx context.Context, userId string, exceptSessionId string) error {
+	_, err := s.DB.ExecContext(ctx, s.query(`DELETE FROM {{sessions}} WHERE user_id = ? AND id NOT IN (?, ?)`), userId,
+		sessionDigest(exceptSessionId), sqliteLegacySessionKey(exceptSessionId))
+	return err
+}
+
+// Deletes up to limit sessions that expired before the given time, returning how many were deleted
+func (s *SQLiteAuthStore) DeleteExpiredSessions(ctx context.Context, before time.Time, limit int) (int, error) {
+	if limit <= 0 {
+		limit = -1 // no limit
+	}
+	deleteExpiredQuery := `
+	DELETE FROM {{sessions}}
+	WHERE id IN (
+		SELECT id FROM {{sessions}}
+		WHERE expires_at < ?
+		LIMIT ?
+	)
+	`
+	result, err := s.DB.ExecContext(ctx, s.query(deleteExpiredQuery), before.Unix(), limit)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
+
+// Updates the expiry, last seen time and data of an existing session
+func (s *SQLiteAuthStore) UpdateSession(ctx context.Context, session sessions.Session) error {
+	data, err := session.Data.Encode()
+	if err != nil {
+		return err
+	}
+	updateSessionQuery := `
+	UPDATE {{sessions}}
+	SET expires_at = ?, last_seen_at = ?, data = ?
+	WHERE id IN (?, ?)
+	`
+	result, err := s.DB.ExecContext(ctx, s.query(updateSessionQuery), session.ExpiresAt.Unix(), session.LastSeenAt.Unix(), data,
+		sessionDigest(string(session.Id)), sqliteLegacySessionKey(string(session.Id)))
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sessions.ErrSessionNotFound
+	}
+	return nil
+}
+
+// Replaces the data of an existing session, leaving its expiry and last seen time as they are
+func (s *SQLiteAuthStore) UpdateSessionData(ctx context.Context, sessionId string, data sessions.SessionData) error {
+	encoded, err := data.Encode()
+	if err != nil {
+		return err
+	}
+	updateDataQuery := `
+	UPDATE {{sessions}}
+	SET data = ?
+	WHERE id IN (?, ?)
+	`
+	result, err := s.DB.ExecContext(ctx, s.query(updateDataQuery), encoded, sessionDigest(sessionId), sqliteLegacySessionKey(sessionId))
+	if err != nil {
+		return err
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if affected == 0 {
+		return sessions.ErrSessionNotFound
+	}
+	return nil
+}
+
+// Replaces the ids of sessions that the migration to hashed session ids marked as plaintext with their digests, in a
+// single transaction, and returns how many were replaced. MigrateSQLite does this as well, so it is only needed for a
+// database that was migrated without it. Sessions saved by an older version of the store, which doesn't mark its ids,
+// are not found or hashed, so old and new versions of the store can't share a sessions table.
+func (s *SQLiteAuthStore) HashLegacySessionIds(ctx context.Context) (int, error) {
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	n, err := hashSQLiteLegacySessionIds(ctx, tx, s.names)
+	if err != nil {
+		return 0, err
+	}
+	return n, tx.Commit()
+}
+
+// Loads the login throttling state for a key, returning an empty state if there is none
+func (s *SQLiteAuthStore) LoadLoginAttempts(ctx context.Context, key string) (sessions.LoginAttempts, error) {
+	a := sessions.LoginAttempts{Key: key}
+	var updatedAtMilli, lockedUntilMilli int64
+	query := `SELECT tokens, updated_at, failures, locked_until FROM {{login_attempts}} WHERE attempt_key = ?`
+	err := s.DB.QueryRowContext(ctx, s.query(query), key).Scan(&a.Tokens, &updatedAtMilli, &a.Failures, &lockedUntilMilli)
+	if errors.Is(err, sql.ErrNoRows) {
+		return sessions.LoginAttempts{Key: key}, nil
+	} else if err != nil {
+		return a, err
+	}
+	a.UpdatedAt = time.UnixMilli(updatedAtMilli)
+	a.LockedUntil = time.UnixMilli(lockedUntilMilli)
+	return a, nil
+}
+
+// Inserts or replaces the login throttling state for a key
+func (s *SQLiteAuthStore) SaveLoginAttempts(ctx context.Context, a sessions.LoginAttempts) error {
+	saveLoginAttemptsQuery := `
+		INSERT INTO {{login_attempts}} (attempt_key, tokens, updated_at, failures, locked_until)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (attempt_key) DO UPDATE SET
+		tokens = excluded.tokens,
+		updated_at = excluded.updated_at,
+		failures = excluded.failures,
+		locked_until = excluded.locked_until
+		`
+	_, err := s.DB.ExecContext(ctx, s.query(saveLoginAttemptsQuery), a.Key, a.Tokens, a.UpdatedAt.UnixMilli(), a.Failures, a.LockedUntil.UnixMilli())
+	return err
+}
+
+// Deletes the login throttling state for a key
+func (s *SQLiteAuthStore) DeleteLoginAttempts(ctx context.Context, key string) error {
+	_, err := s.DB.ExecContext(ctx, s.query(`DELETE FROM {{login_attempts}} WHERE attempt_key = ?`), key)
+	return err
+}
+
+// Deletes up to limit keys whose last attempt and lockout both ended before the given time, returning how many were
+// deleted
+func (s *SQLiteAuthStore) DeleteLoginAttemptsBefore(ctx context.Context, before time.Time, limit int) (int, error) {
+	if limit <= 0 {
+		limit = -1 // no limit
+	}
+	deleteStaleQuery := `
+	DELETE FROM {{login_attempts}}
+	WHERE attempt_key IN (
+		SELECT attempt_key FROM {{login_attempts}}
+		WHERE updated_at < ? AND locked_until < ?
+		LIMIT ?
+	)
+	`
+	result, err := s.DB.ExecContext(ctx, s.query(deleteStaleQuery), before.UnixMilli(), before.UnixMilli(), limit)
+	if err != nil {
+		return 0, err
+	}
+	affected, err := result.RowsAffected()
+	return int(affected), err
+}
@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalidTableName = errors.New("Table and schema names must start with a letter or underscore, contain only letters, digits and underscores, and be at most 63 characters long")
+
+var ErrSchemaNotSupported = errors.New("The SQLite store does not support a schema name, use a table prefix instead")
+
+// A StoreOption configures optional behavior of a SQL store when passed to NewSQLiteStore or NewPostgresAuthStore.
+type StoreOption func(*storeConfig)
+
+type storeConfig struct {
+	autoMigrate bool
+	names       TableNames
+	prefix      string
+	schema      string
+}
+
+// The names of the tables a SQL store keeps its data in.
+type TableNames struct {
+	Users         string
+	Sessions      string
+	LoginAttempts string
+	// The table recording which schema migrations have been applied.
+	SchemaMigrations string
+}
+
+// Returns the table names the SQL stores use by default.
+func DefaultTableNames() TableNames {
+	return TableNames{
+		Users:            "users",
+		Sessions:         "sessions",
+		LoginAttempts:    "login_attempts",
+		SchemaMigrations: "schema_migrations",
+	}
+}
+
+func newStoreConfig(opts []StoreOption) (storeConfig, error) {
+	cfg := storeConfig{autoMigrate: true, names: DefaultTableNames()}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	cfg.names = TableNames{
+		Users:            cfg.prefix + cfg.names.Users,
+		Sessions:         cfg.prefix + cfg.names.Sessions,
+		LoginAttempts:    cfg.prefix + cfg.names.LoginAttempts,
+		SchemaMigrations: cfg.prefix + cfg.names.SchemaMigrations,
+	}
+	for _, name := range []string{cfg.names.Users, cfg.names.Sessions, cfg.names.LoginAttempts, cfg.names.SchemaMigrations} {
+		if !validIdentifier(name) {
+			return cfg, ErrInvalidTableName
+		}
+	}
+	if cfg.schema != "" && !validIdentifier(cfg.schema) {
+		return cfg, ErrInvalidTableName
+	}
+	return cfg, nil
+}
+
+// Stops the store from applying its schema migrations when it is created, for databases where migrations are
+// applied out-of-band, for example by a DBA from SQLiteMigrations or PostgresMigrations. Migrations can also be
+// applied from code with MigrateSQLite or MigratePostgres.
+func WithoutAutoMigrate() StoreOption {
+	return func(cfg *storeConfig) {
+		cfg.autoMigrate = false
+	}
+}
+
+// Prefixes the name of every table the store uses, for example "auth_" for auth_users and auth_sessions, so that
+// they don't collide with the application's own tables. The prefix is added to names set with WithTableNames too.
+func WithTablePrefix(prefix string) StoreOption {
+	return func(cfg *storeConfig) {
+		cfg.prefix = prefix
+	}
+}
+
+// Sets the names of the tables the store uses. Empty fields keep their default name.
+func WithTableNames(names TableNames) StoreOption {
+	return func(cfg *storeConfig) {
+		if names.Users != "" {
+			cfg.names.Users = names.Users
+		}
+		if names.Sessions != "" {
+			cfg.names.Sessions = names.Sessions
+		}
+		if names.LoginAttempts != "" {
+			cfg.names.LoginAttempts = names.LoginAttempts
+		}
+		if names.SchemaMigrations != "" {
+			cfg.names.SchemaMigrations = names.SchemaMigrations
+		}
+	}
+}
+
+// Puts the Postgres store's tables in the given schema, which is created by the migrations if it doesn't exist.
+// Not supported by the SQLite store.
+func WithSchema(schema string) StoreOption {
+	return func(cfg *storeConfig) {
+		cfg.schema = schema
+	}
+}
+
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+// Reports whether a table or schema name is safe to put into SQL. Names are quoted as well, but restricting them
+// keeps them portable between SQLite and Postgres and rules out injection entirely.
+func validIdentifier(name string) bool {
+	return identifierPattern.MatchString(name)
+}
+
+// Fills in the table names of a SQL statement. Statements refer to tables as {{users}}, {{sessions}},
+// {{login_attempts}} and {{schema_migrations}}, which expand to quoted and, with a schema, qualified names, and to
+// indexes as {{sessions_user_id_idx}} and so on, which expand to a quoted name derived from the table's name. Index
+// names are never qualified, since Postgres always creates an index in the schema of its table.
+type sqlNames struct {
+	replacer *strings.Replacer
+}
+
+func newSQLNames(cfg storeConfig) *sqlNames {
+	table := func(name string) string {
+		if cfg.schema != "" {
+			return `"` + cfg.schema + `"."` + name + `"`
+		}
+		return `"` + name + `"`
+	}
+	index := func(name string, suffix string) string {
+		return `"` + name + "_" + suffix + `"`
+	}
+	return &sqlNames{replacer: strings.NewReplacer(
+		"{{users}}", table(cfg.names.Users),
+		"{{sessions}}", table(cfg.names.Sessions),
+		"{{sessions_new}}", table(cfg.names.Sessions+"_new"),
+		"{{login_attempts}}", table(cfg.names.LoginAttempts),
+		"{{schema_migrations}}", table(cfg.names.SchemaMigrations),
+		"{{users_username_idx}}", index(cfg.names.Users, "username_idx"),
+		"{{sessions_user_id_idx}}", index(cfg.names.Sessions, "user_id_idx"),
+		"{{sessions_expires_at_idx}}", index(cfg.names.Sessions, "expires_at_idx"),
+	)}
+}
+
+// the names used by stores that were created without NewSQLiteStore or NewPostgresAuthStore
+var defaultSQLNames = newSQLNames(storeConfig{names: DefaultTableNames()})
+
+func (n *sqlNames) expand(query string) string {
+	if n == nil {
+		n = defaultSQLNames
+	}
+	return n.replacer.Replace(query)
+}
@@ -0,0 +1,37 @@
+package auth
+
+import (
+	"testing"
+
+	"github.com/cameronmore/go-sessions/sessions"
+	"github.com/cameronmore/go-sessions/sessions/storetest"
+)
+
+func TestSQLiteAuthStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) sessions.AuthStore {
+		store, err := NewSQLiteStore(openSQLite(t))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+func TestMemoryAuthStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) sessions.AuthStore {
+		return NewMemoryAuthStore()
+	})
+}
+
+func TestRedisAuthStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) sessions.AuthStore {
+		store, _ := newTestRedisStore(t)
+		return store
+	})
+}
+
+func TestCompositeAuthStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) sessions.AuthStore {
+		return sessions.NewCompositeAuthStore(NewMemoryAuthStore(), NewMemoryAuthStore())
+	})
+}
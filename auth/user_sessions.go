@@ -0,0 +1,148 @@
+package auth
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// One of the logged-in user's sessions as listed by ListSessionsHandler. The Id is the session's digest rather than
+// the session id itself, so it can be shown to the user and sent back to RevokeSessionHandler without leaking the
+// cookie value.
+type SessionResponse struct {
+	Id         string    `json:"id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	UserAgent  string    `json:"user_agent"`
+	IPAddress  string    `json:"ip_address"`
+	// Whether this is the session the request was made with.
+	Current bool `json:"current"`
+}
+
+// The body of a successful response from ListSessionsHandler.
+type SessionListResponse struct {
+	Sessions []SessionResponse `json:"sessions"`
+}
+
+// The body expected by RevokeSessionHandler, either as a JSON object or as a form field with the same name.
+type SessionRevocation struct {
+	Id string `json:"id"`
+}
+
+// Handles listing the logged-in user's active sessions, most recently used first. It must be used behind
+// Authmiddleware. It responds with a SessionListResponse, otherwise with an ErrorResponse.
+func (ac *AuthContext) ListSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	current, list, ok := ac.activeUserSessions(w, r)
+	if !ok {
+		return
+	}
+	resp := SessionListResponse{Sessions: make([]SessionResponse, 0, len(list))}
+	// stores that keep only digests list those, so sessions are compared by their digests
+	currentDigest := current.Id.Digest()
+	for _, s := range list {
+		resp.Sessions = append(resp.Sessions, SessionResponse{
+			Id:         s.Id.Digest(),
+			CreatedAt:  s.CreatedAt,
+			LastSeenAt: s.LastSeenAt,
+			ExpiresAt:  s.ExpiresAt,
+			UserAgent:  s.UserAgent,
+			IPAddress:  s.IPAddress,
+			Current:    s.Id.Digest() == currentDigest,
+		})
+	}
+	sort.SliceStable(resp.Sessions, func(i, j int) bool {
+		return resp.Sessions[i].LastSeenAt.After(resp.Sessions[j].LastSeenAt)
+	})
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Handles revoking one of the logged-in user's sessions, for example one left logged in on a lost device. It must
+// be used behind Authmiddleware.
+//
+// The expected request to this endpoint is a JSON object with the form:
+//
+// { "id" : "SESSION ID FROM ListSessionsHandler" }
+//
+// or a form post with an id field. Revoking the current session logs the user out. On success it responds with a
+// MessageResponse, otherwise with an ErrorResponse.
+func (ac *AuthContext) RevokeSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var req SessionRevocation
+	err := decodeRequest(w, r, &req, func(get func(string) string) {
+		req.Id = get("id")
+	})
+	if err != nil {
+		writeError(w, requestDecodeError(err))
+		return
+	}
+	if req.Id == "" {
+		writeErrorCode(w, http.StatusBadRequest, ErrCodeInvalidRequest, "A session id is required")
+		return
+	}
+
+	current, list, ok := ac.activeUserSessions(w, r)
+	if !ok {
+		return
+	}
+	for _, s := range list {
+		if s.Id.Digest() != req.Id {
+			continue
+		}
+		if err := ac.Sessions.DeleteSessionById(r.Context(), string(s.Id)); err != nil {
+			log.Printf("Error revoking session of user %s: %s", s.UserId, err)
+			writeError(w, internalError)
+			return
+		}
+		if s.Id.Digest() == current.Id.Digest() {
+			http.SetCookie(w, sessions.LogoutHandler(ac.Cookie, ac.cookieOptions()...))
+			ac.setCSRFCookie(w, "")
+		}
+		writeJSON(w, http.StatusOK, MessageResponse{Message: "Session revoked"})
+		return
+	}
+	writeErrorCode(w, http.StatusNotFound, ErrCodeSessionNotFound, "Session not found")
+}
+
+// Handles logging the user out everywhere but the current session. It must be used behind Authmiddleware. There is
+// no expected request body for this endpoint. On success it responds with a MessageResponse, otherwise with an
+// ErrorResponse.
+func (ac *AuthContext) RevokeOtherSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	current, ok := SessionFromContext(r.Context())
+	if !ok || current.UserId == "" {
+		writeErrorCode(w, http.StatusUnauthorized, ErrCodeNotAuthenticated, "Not authenticated")
+		return
+	}
+	if err := ac.Sessions.DeleteSessionsByUserId(r.Context(), current.UserId, string(current.Id)); err != nil {
+		log.Printf("Error revoking other sessions of user %s: %s", current.UserId, err)
+		writeError(w, internalError)
+		return
+	}
+	writeJSON(w, http.StatusOK, MessageResponse{Message: "Other sessions revoked"})
+}
+
+// Returns the session the request was made with and the unexpired sessions of its user. If they cannot be loaded
+// an error response is written and false is returned.
+func (ac *AuthContext) activeUserSessions(w http.ResponseWriter, r *http.Request) (sessions.Session, []sessions.Session, bool) {
+	current, ok := SessionFromContext(r.Context())
+	if !ok || current.UserId == "" {
+		writeErrorCode(w, http.StatusUnauthorized, ErrCodeNotAuthenticated, "Not authenticated")
+		return current, nil, false
+	}
+	list, err := ac.Sessions.ListSessionsByUserId(r.Context(), current.UserId)
+	if err != nil {
+		log.Printf("Error listing sessions of user %s: %s", current.UserId, err)
+		writeError(w, internalError)
+		return current, nil, false
+	}
+	now := ac.Clock.Now()
+	active := list[:0]
+	for _, s := range list {
+		if !ac.sessionExpired(s, now) {
+			active = append(active, s)
+		}
+	}
+	return current, active, true
+}
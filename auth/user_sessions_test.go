@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+func TestSessionHandlers(t *testing.T) {
+	store := NewMemoryAuthStore()
+	store.SaveUser(t.Context(), sessions.User{UserId: "01", Username: "alice", HashedPassword: "$slow$correct horse battery"})
+	ac := NewAuthContext(store, sessions.NewKeyringFromSecret("test signing secret"), time.Hour, WithPasswordHasher(&slowHasher{}))
+
+	login := func(userAgent string) *http.Cookie {
+		r := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(`{"username":"alice","password":"correct horse battery"}`))
+		r.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		ac.LoginHandler(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("login returned %d: %s", w.Code, w.Body)
+		}
+		return w.Result().Cookies()[0]
+	}
+	serve := func(handler http.HandlerFunc, cookie *http.Cookie, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		r.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		ac.Authmiddleware(handler).ServeHTTP(w, r)
+		return w
+	}
+	list := func(cookie *http.Cookie) []SessionResponse {
+		w := serve(ac.ListSessionsHandler, cookie, "")
+		var resp SessionListResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatalf("decoding the session list: %s", err)
+		}
+		return resp.Sessions
+	}
+
+	laptop, phone, tablet := login("laptop"), login("phone"), login("tablet")
+
+	listed := list(laptop)
+	if len(listed) != 3 {
+		t.Fatalf("listed %d sessions, want 3", len(listed))
+	}
+	var phoneId string
+	for _, s := range listed {
+		if s.Current != (s.UserAgent == "laptop") {
+			t.Errorf("session from %s has current = %t", s.UserAgent, s.Current)
+		}
+		if s.UserAgent == "phone" {
+			phoneId = s.Id
+		}
+		if strings.Contains(laptop.Value+phone.Value+tablet.Value, s.Id) {
+			t.Errorf("listed session id %s is part of a session cookie", s.Id)
+		}
+	}
+
+	if w := serve(ac.RevokeSessionHandler, laptop, `{"id":"`+phoneId+`"}`); w.Code != http.StatusOK {
+		t.Errorf("revoking the phone's session returned %d: %s", w.Code, w.Body)
+	}
+	if w := serve(ac.ListSessionsHandler, phone, ""); w.Code != http.StatusUnauthorized {
+		t.Errorf("request with the revoked session returned %d, want %d", w.Code, http.StatusUnauthorized)
+	}
+	if w := serve(ac.RevokeSessionHandler, laptop, `{"id":"`+phoneId+`"}`); w.Code != http.StatusNotFound {
+		t.Errorf("revoking an already revoked session returned %d, want %d", w.Code, http.StatusNotFound)
+	}
+
+	if w := serve(ac.ChangePasswordHandler, tablet, `{"current_password":"correct horse battery","new_password":"a much better passphrase"}`); w.Code != http.StatusOK {
+		t.Fatalf("password change returned %d: %s", w.Code, w.Body)
+	}
+	if listed := list(tablet); len(listed) != 1 || listed[0].UserAgent != "tablet" {
+		t.Errorf("sessions after a password change from the tablet = %+v, want only the tablet's", listed)
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/cameronmore/go-sessions/auth"
 	"github.com/cameronmore/go-sessions/env"
+	"github.com/cameronmore/go-sessions/sessions"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	_ "github.com/jackc/pgx/v5/stdlib"
@@ -48,16 +49,29 @@ func main() {
 
 	fmt.Println("Sucessfully connected to db")
 
-	// Define a new SQLite store that implements the interface
+	// Define a new Postgres store that implements the interface. It creates or upgrades its tables on startup; pass
+	// auth.WithoutAutoMigrate() if you apply the migrations yourself.
 	postgresAuthStore, err := auth.NewPostgresAuthStore(db)
 	//or sqliteAuthStore, err := auth.NewSQLiteStore(db)
 	if err != nil {
 		panic(err)
 	}
 	fmt.Printf("Auth store is set up with db type: %s\n", sqlDBType)
-	// pass that store to the Authcontext that expects the interface
-	authCtx := auth.NewAuthContext(postgresAuthStore, secret, 7*24*time.Hour)
-	// or authCtx := auth.NewAuthContext(sqliteAuthStore, secret, 7*24*time.Hour)
+	// wrap the secret in a keyring used to sign session cookies. To rotate the secret without logging everyone out,
+	// give the new secret an id and keep the old one around for verification only:
+	// keys, err := sessions.NewKeyring(sessions.Key{Id: "2", Secret: newSecret}, sessions.Key{Secret: secret})
+	keys := sessions.NewKeyringFromSecret(secret)
+
+	// pass that store to the Authcontext that expects the interface. Sessions last a week from the user's last
+	// renewal, and are extended while they keep using the app, but never past 30 days from login.
+	// Expired sessions are deleted from the database every 10 minutes, 1000 rows at a time.
+	authCtx := auth.NewAuthContext(postgresAuthStore, keys, 7*24*time.Hour,
+		auth.WithMaxLifetime(30*24*time.Hour),
+		auth.WithSessionReaper(10*time.Minute, 1000),
+	)
+	// stop the reaper on the way out
+	defer authCtx.Close()
+	// or authCtx := auth.NewAuthContext(sqliteAuthStore, keys, 7*24*time.Hour)
 
 	// Now define your router. In this example, I'm using Chi
 	r := chi.NewRouter()
@@ -75,7 +89,8 @@ func main() {
 	authRouter := chi.NewRouter()
 	authRouter.Post("/login", authCtx.LoginHandler)
 	authRouter.Post("/register", authCtx.RegisterHandler)
-	authRouter.Get("/logout", authCtx.LogoutHandler)
+	// logout changes state, so it is a POST that needs the CSRF token like any other authenticated write
+	authRouter.With(authCtx.CSRFMiddleware).Post("/logout", authCtx.LogoutHandler)
 	// I'm mounting them all to the /auth endpoint, so a user can hit /auth/register to make a new account and
 	// then hit /api/... to access any protected data
 	r.Mount("/auth", authRouter)
@@ -83,13 +98,25 @@ func main() {
 	// here we're defining the actual protected endpoints by using the authentication context's auth middleware
 	apiRouter := chi.NewRouter()
 	apiRouter.Use(authCtx.Authmiddleware)
+	// reject POST, PUT, PATCH and DELETE requests that don't send back the X-CSRF-Token header (or csrf_token form
+	// field). The token is exposed to the frontend in the csrf_token cookie and X-CSRF-Token response header.
+	apiRouter.Use(authCtx.CSRFMiddleware)
 	apiRouter.Get("/userData", func(w http.ResponseWriter, r *http.Request) {
 		// That middleware provices the user id as a context so you know what client
 		// is making the request.
-		userId := r.Context().Value("userId").(string)
+		userId, _ := auth.UserIdFromContext(r.Context())
 		w.Write(fmt.Appendf(nil, "You requested user data for %s", userId))
 	})
 
+	// the logged-in user can manage their own account. Each of these asks for the current password again.
+	apiRouter.Post("/account/password", authCtx.ChangePasswordHandler)
+	apiRouter.Post("/account/username", authCtx.ChangeUsernameHandler)
+	apiRouter.Delete("/account", authCtx.DeleteAccountHandler)
+	// and see where they are logged in, and log out any of those sessions or all but the current one
+	apiRouter.Get("/account/sessions", authCtx.ListSessionsHandler)
+	apiRouter.Post("/account/sessions/revoke", authCtx.RevokeSessionHandler)
+	apiRouter.Post("/account/sessions/revoke-others", authCtx.RevokeOtherSessionsHandler)
+
 	// and we mount this protected router to the main router
 	r.Mount("/api", apiRouter)
 
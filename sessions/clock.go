@@ -0,0 +1,16 @@
+package sessions
+
+import "time"
+
+// Tells the current time. Code that decides when sessions expire takes a Clock rather than calling time.Now, so
+// that tests can control time.
+type Clock interface {
+	Now() time.Time
+}
+
+// The Clock that reads the system time.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
@@ -0,0 +1,110 @@
+package sessions
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+const hostPrefix = "__Host-"
+
+// Settings for the session cookie. The zero value of each field falls back to the matching field of
+// DefaultCookieConfig, except Secure, Partitioned and HostPrefix which are plain booleans.
+type CookieConfig struct {
+	// The name of the cookie, "session_id" by default. Give sibling apps on the same domain different names so their
+	// cookies don't overwrite each other.
+	Name string
+	// The domain the cookie is sent to. Leave empty to send it only to the host that set it.
+	Domain string
+	// The path the cookie is sent to, "/" by default.
+	Path     string
+	SameSite http.SameSite
+	// Whether the cookie is only sent over HTTPS. Turn this off to run locally over plain HTTP.
+	Secure bool
+	// Whether the cookie is partitioned by top-level site (CHIPS), for apps embedded in third-party iframes.
+	Partitioned bool
+	// Whether the cookie name is prefixed with __Host-, which makes browsers refuse the cookie unless it is Secure,
+	// has Path "/" and has no Domain. This guarantees that no other subdomain can set or shadow the cookie.
+	HostPrefix bool
+}
+
+// Returns the default cookie settings: a secure, HttpOnly cookie named session_id on path "/" with SameSite=Lax.
+func DefaultCookieConfig() CookieConfig {
+	return CookieConfig{
+		Name:     "session_id",
+		Path:     "/",
+		SameSite: http.SameSiteLaxMode,
+		Secure:   true,
+	}
+}
+
+// Returns the name the cookie is set under, including the __Host- prefix when it is enabled.
+func (c CookieConfig) CookieName() string {
+	name := c.Name
+	if name == "" {
+		name = DefaultCookieConfig().Name
+	}
+	if c.HostPrefix && !strings.HasPrefix(name, hostPrefix) {
+		name = hostPrefix + name
+	}
+	return name
+}
+
+// Returns an error if browsers would reject cookies with these settings.
+func (c CookieConfig) Validate() error {
+	if c.HostPrefix && (!c.Secure || c.Domain != "" || (c.Path != "" && c.Path != "/")) {
+		return ErrInvalidHostPrefixCookie
+	}
+	if c.SameSite == http.SameSiteNoneMode && !c.Secure {
+		return ErrInsecureSameSiteNone
+	}
+	if c.Partitioned && !c.Secure {
+		return ErrInsecurePartitionedCookie
+	}
+	return nil
+}
+
+// builds a session cookie with these settings
+func (c CookieConfig) cookie(value string, expiresAt time.Time) *http.Cookie {
+	defaults := DefaultCookieConfig()
+	cookie := &http.Cookie{
+		Name:        c.CookieName(),
+		Value:       value,
+		Domain:      c.Domain,
+		Path:        c.Path,
+		Expires:     expiresAt,
+		HttpOnly:    true,
+		Secure:      c.Secure,
+		SameSite:    c.SameSite,
+		Partitioned: c.Partitioned,
+	}
+	if cookie.Path == "" {
+		cookie.Path = defaults.Path
+	}
+	if cookie.SameSite == 0 {
+		cookie.SameSite = defaults.SameSite
+	}
+	// a __Host- cookie that breaks the prefix rules is silently dropped by browsers, so enforce them here
+	if c.HostPrefix {
+		cookie.Secure = true
+		cookie.Domain = ""
+		cookie.Path = "/"
+	}
+	return cookie
+}
+
+// Returns a cookie that shares the session cookie's domain, path and security settings, for values that travel
+// alongside the session such as CSRF tokens. Unlike the session cookie it is readable by JavaScript and lasts for
+// the browser session. An empty value returns a cookie that deletes it.
+func (c CookieConfig) CompanionCookie(name string, value string) *http.Cookie {
+	cookie := c.cookie(value, time.Time{})
+	cookie.Name = name
+	if c.HostPrefix && !strings.HasPrefix(name, hostPrefix) {
+		cookie.Name = hostPrefix + name
+	}
+	cookie.HttpOnly = false
+	if value == "" {
+		cookie.MaxAge = -1
+	}
+	return cookie
+}
@@ -0,0 +1,37 @@
+package sessions
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestCookieConfigHostPrefix(t *testing.T) {
+	cfg := CookieConfig{Name: "app", Domain: "example.com", Path: "/app", HostPrefix: true}
+	if err := cfg.Validate(); !errors.Is(err, ErrInvalidHostPrefixCookie) {
+		t.Errorf("Validate() error = %v, want %v", err, ErrInvalidHostPrefixCookie)
+	}
+
+	cookie := cfg.cookie("value", time.Now())
+	if cookie.Name != "__Host-app" || !cookie.Secure || cookie.Domain != "" || cookie.Path != "/" {
+		t.Errorf("cookie() = %+v, want a secure __Host-app cookie on / without a domain", cookie)
+	}
+}
+
+func TestCookieConfigRoundTrip(t *testing.T) {
+	keys := NewKeyringFromSecret("test signing secret")
+	cfg := CookieConfig{Name: "other_app", Path: "/", SameSite: http.SameSiteStrictMode}
+
+	cookie, sessionId := NewCookieWithSessionId(keys, time.Hour, cfg)
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookie)
+
+	if _, ok := VerifyRequestSessionCookie(r, keys, DefaultCookieConfig()); ok {
+		t.Error("VerifyRequestSessionCookie() found the cookie under the default name")
+	}
+	got, ok := VerifyRequestSessionCookie(r, keys, cfg)
+	if !ok || got != sessionId {
+		t.Errorf("VerifyRequestSessionCookie() = %q, %v, want %q, true", got, ok, sessionId)
+	}
+}
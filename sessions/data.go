@@ -0,0 +1,73 @@
+package sessions
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+)
+
+// Values kept with a session on the server, such as a cart, flash messages, a selected tenant or the state of an
+// OAuth flow. Each value is stored as JSON under its key and stores save the whole map along with the session.
+type SessionData map[string]json.RawMessage
+
+// Returns a copy of the data that shares nothing with it, or nil if the data is empty.
+func (d SessionData) Clone() SessionData {
+	if len(d) == 0 {
+		return nil
+	}
+	clone := make(SessionData, len(d))
+	for key, value := range d {
+		clone[key] = slices.Clone(value)
+	}
+	return clone
+}
+
+// Returns the data as a JSON object, which is "{}" when the data is empty.
+func (d SessionData) Encode() (string, error) {
+	if len(d) == 0 {
+		return "{}", nil
+	}
+	b, err := json.Marshal(d)
+	return string(b), err
+}
+
+// Returns the data in a JSON object written by Encode. An empty object, empty string or null returns nil data.
+func DecodeSessionData(encoded string) (SessionData, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	var d SessionData
+	if err := json.Unmarshal([]byte(encoded), &d); err != nil {
+		return nil, err
+	}
+	if len(d) == 0 {
+		return nil, nil
+	}
+	return d, nil
+}
+
+// Reports whether two sets of data hold the same keys with the same JSON values, regardless of how the JSON is
+// formatted.
+func (d SessionData) Equal(other SessionData) bool {
+	return maps.EqualFunc(d, other, func(a json.RawMessage, b json.RawMessage) bool {
+		var va, vb any
+		if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+			return slices.Equal(a, b)
+		}
+		return equalJSON(va, vb)
+	})
+}
+
+// compares two values decoded from JSON into interfaces
+func equalJSON(a any, b any) bool {
+	switch va := a.(type) {
+	case map[string]any:
+		vb, ok := b.(map[string]any)
+		return ok && maps.EqualFunc(va, vb, equalJSON)
+	case []any:
+		vb, ok := b.([]any)
+		return ok && slices.EqualFunc(va, vb, equalJSON)
+	default:
+		return a == b
+	}
+}
@@ -9,3 +9,27 @@ var ErrInvalidSessionSignature = errors.New("The signed session id had an invali
 var ErrUserNotFound = errors.New("The user was not found with that username or id")
 
 var ErrSessionNotFound = errors.New("The session was not found")
+
+var ErrUnknownSigningKey = errors.New("The session id was signed with a key that is not in the keyring")
+
+var ErrEmptySigningKey = errors.New("A signing key must have a non-empty secret")
+
+var ErrShortSigningKey = errors.New("A signing key's secret must be at least 16 bytes long")
+
+var ErrInvalidKeyId = errors.New("A signing key id cannot contain a period")
+
+var ErrDuplicateKeyId = errors.New("A signing key with that id is already in the keyring")
+
+var ErrActiveKeyRemoval = errors.New("The active signing key cannot be removed from the keyring")
+
+var ErrInvalidHostPrefixCookie = errors.New("A __Host- prefixed cookie must be Secure, have Path \"/\" and no Domain")
+
+var ErrInsecureSameSiteNone = errors.New("A SameSite=None cookie must be Secure")
+
+var ErrInsecurePartitionedCookie = errors.New("A partitioned cookie must be Secure")
+
+var ErrUserExists = errors.New("A user with that id or username already exists")
+
+var ErrSessionExists = errors.New("A session with that id already exists")
+
+var ErrNotSupported = errors.New("The store does not support this operation")
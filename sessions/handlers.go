@@ -6,27 +6,18 @@ import (
 )
 
 // Handles the registration of a user by making a new cookie
-func RegisterHandler(secret string, d time.Duration) (sessionId string, cookie *http.Cookie) {
-	sessionId = newSessionId()
-	signesSessionId := signSessionId(sessionId, secret)
-	cookie = newCookie(signesSessionId, d)
-	return
+func RegisterHandler(keys *Keyring, d time.Duration, cfg CookieConfig, opts ...Option) (sessionId string, cookie *http.Cookie) {
+	return newIssuer(opts).newCookie(keys, d, cfg)
 }
 
 // Handles the login of a user by making a new cookie
-func LoginHandler(secret string, d time.Duration) (string, *http.Cookie) {
-	return RegisterHandler(secret, d)
+func LoginHandler(keys *Keyring, d time.Duration, cfg CookieConfig, opts ...Option) (string, *http.Cookie) {
+	return RegisterHandler(keys, d, cfg, opts...)
 }
 
 // Handles the logout of a user by making an expired cookie
-func LogoutHandler() *http.Cookie {
-	return &http.Cookie{
-		Name:     "session_id",
-		Value:    "",
-		Path:     "/",
-		Expires:  time.Now().Add(-24 * time.Minute),
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteLaxMode,
-	}
+func LogoutHandler(cfg CookieConfig, opts ...Option) *http.Cookie {
+	cookie := cfg.cookie("", newIssuer(opts).clock.Now().Add(-24*time.Minute))
+	cookie.MaxAge = -1
+	return cookie
 }
@@ -0,0 +1,37 @@
+package sessions
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+
+	"github.com/google/uuid"
+	"github.com/oklog/ulid/v2"
+)
+
+// Returns a new unique id for a session or user. Session ids are all that stands between an attacker and a
+// logged-in account, so generators used for them must draw on a cryptographically secure source. Ids must not
+// contain a ".", which separates the session id from its signature in the cookie.
+type IdGenerator func() string
+
+// Returns a random (version 4) UUID, the default session id.
+func UUIDv4() string {
+	return uuid.New().String()
+}
+
+// Returns a time-ordered (version 7) UUID. Its first 48 bits are the creation time in milliseconds, which keeps
+// database indexes compact but reveals when the id was made.
+func UUIDv7() string {
+	return uuid.Must(uuid.NewV7()).String()
+}
+
+// Returns a ULID, the default user id. Like a UUIDv7 it sorts by creation time, which it reveals.
+func ULID() string {
+	return ulid.Make().String()
+}
+
+// Returns 256 random bits encoded as unpadded base64url, for session ids with more entropy than a UUID's 122 bits.
+func Random256() string {
+	b := make([]byte, 32)
+	rand.Read(b) // never returns an error and crashes the program if the system's source fails
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,58 @@
+package sessions
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdGenerators(t *testing.T) {
+	generators := map[string]struct {
+		gen    IdGenerator
+		length int
+	}{
+		"UUIDv4":    {UUIDv4, 36},
+		"UUIDv7":    {UUIDv7, 36},
+		"ULID":      {ULID, 26},
+		"Random256": {Random256, 43},
+	}
+	keys := NewKeyringFromSecret("test signing secret")
+	for name, g := range generators {
+		seen := make(map[string]bool)
+		for range 100 {
+			id := g.gen()
+			if len(id) != g.length || strings.Contains(id, ".") || seen[id] {
+				t.Fatalf("%s() = %q, want a new %d character id without a dot", name, id, g.length)
+			}
+			seen[id] = true
+		}
+
+		// the ids have to survive being signed and verified
+		cookie, sessionId := NewCookieWithSessionId(keys, time.Hour, DefaultCookieConfig(), WithSessionIdGenerator(g.gen))
+		if got, err := VerifySessionId(cookie.Value, keys); err != nil || got != sessionId {
+			t.Errorf("VerifySessionId() of a %s session = %q, %v, want %q", name, got, err, sessionId)
+		}
+	}
+}
+
+type fixedClock time.Time
+
+func (c fixedClock) Now() time.Time {
+	return time.Time(c)
+}
+
+func TestCookieOptions(t *testing.T) {
+	now := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+	opts := []Option{WithClock(fixedClock(now)), WithSessionIdGenerator(func() string { return "fixed" })}
+
+	cookie, sessionId := NewCookieWithSessionId(NewKeyringFromSecret("test signing secret"), time.Hour, DefaultCookieConfig(), opts...)
+	if sessionId != "fixed" || !strings.HasPrefix(cookie.Value, "fixed.") {
+		t.Errorf("NewCookieWithSessionId() = %q, %q, want the generated id", cookie.Value, sessionId)
+	}
+	if want := now.Add(time.Hour); !cookie.Expires.Equal(want) {
+		t.Errorf("cookie expires at %v, want %v", cookie.Expires, want)
+	}
+	if cookie := LogoutHandler(DefaultCookieConfig(), opts...); !cookie.Expires.Before(now) {
+		t.Errorf("logout cookie expires at %v, want before %v", cookie.Expires, now)
+	}
+}
@@ -0,0 +1,162 @@
+package sessions
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strings"
+	"sync"
+)
+
+// The shortest secret a signing key may have, in bytes. Secrets should be random; 32 bytes or more is better.
+const MinSecretLength = 16
+
+// A signing key used for session cookies. The Id is embedded in every cookie signed with the key so that the
+// matching secret can be found when the cookie comes back. A key with an empty Id signs cookies in the original
+// unversioned format (sessionId.signature), which lets cookies issued before key IDs existed keep verifying.
+type Key struct {
+	Id     string
+	Secret string
+}
+
+// A set of signing keys made up of one active key, which signs new cookies, and any number of retired keys that
+// are only used to verify cookies issued before a rotation. It is safe for concurrent use.
+type Keyring struct {
+	mu      sync.RWMutex
+	active  Key
+	retired map[string]Key
+}
+
+// Returns a new Keyring that signs with the active key and also accepts cookies signed by any of the retired keys.
+func NewKeyring(active Key, retired ...Key) (*Keyring, error) {
+	if err := validateKey(active); err != nil {
+		return nil, err
+	}
+	k := &Keyring{
+		active:  active,
+		retired: make(map[string]Key),
+	}
+	for _, key := range retired {
+		if err := validateKey(key); err != nil {
+			return nil, err
+		}
+		if _, exists := k.retired[key.Id]; exists || key.Id == active.Id {
+			return nil, ErrDuplicateKeyId
+		}
+		k.retired[key.Id] = key
+	}
+	return k, nil
+}
+
+// Returns a Keyring with a single unversioned key, which produces the same cookies as a bare secret string did
+// before key IDs were introduced. Use it as the starting point for rotating a single AUTH_SESSION_KEY. Panics if
+// the secret is shorter than MinSecretLength, since cookies signed with it could be forged.
+func NewKeyringFromSecret(secret string) *Keyring {
+	if err := validateKey(Key{Secret: secret}); err != nil {
+		panic(err)
+	}
+	return &Keyring{
+		active:  Key{Secret: secret},
+		retired: make(map[string]Key),
+	}
+}
+
+// Makes the given key the active signing key. The previously active key is retired, so cookies it signed keep
+// verifying until it is removed with RemoveKey.
+func (k *Keyring) Rotate(newActive Key) error {
+	if err := validateKey(newActive); err != nil {
+		return err
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if _, exists := k.retired[newActive.Id]; exists || newActive.Id == k.active.Id {
+		return ErrDuplicateKeyId
+	}
+	k.retired[k.active.Id] = k.active
+	k.active = newActive
+	return nil
+}
+
+// Removes a retired key from the keyring. Cookies signed by that key will no longer verify. The active key cannot
+// be removed.
+func (k *Keyring) RemoveKey(id string) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if id == k.active.Id {
+		return ErrActiveKeyRemoval
+	}
+	if _, exists := k.retired[id]; !exists {
+		return ErrUnknownSigningKey
+	}
+	delete(k.retired, id)
+	return nil
+}
+
+// Returns the id of the key currently used for signing.
+func (k *Keyring) ActiveKeyId() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active.Id
+}
+
+// returns the active key
+func (k *Keyring) activeKey() Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// returns the key with the given id, whether it is active or retired
+func (k *Keyring) lookup(id string) (Key, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if id == k.active.Id {
+		return k.active, true
+	}
+	key, ok := k.retired[id]
+	return key, ok
+}
+
+func validateKey(key Key) error {
+	if key.Secret == "" {
+		return ErrEmptySigningKey
+	}
+	if len(key.Secret) < MinSecretLength {
+		return ErrShortSigningKey
+	}
+	if strings.Contains(key.Id, ".") {
+		return ErrInvalidKeyId
+	}
+	return nil
+}
+
+func mac(secret string, message string) []byte {
+	m := hmac.New(sha256.New, []byte(secret))
+	m.Write([]byte(message))
+	return m.Sum(nil)
+}
+
+// Returns a MAC of the message made with the active key, in the form "keyId.mac". Tags let other parts of the
+// library, such as CSRF tokens, derive values from a session without revealing it and keep verifying across key
+// rotations.
+func (k *Keyring) Tag(message string) string {
+	key := k.activeKey()
+	return key.Id + "." + base64.URLEncoding.EncodeToString(mac(key.Secret, message))
+}
+
+// Reports whether the tag was made by Tag for this message with any key in the keyring.
+func (k *Keyring) CheckTag(message string, tag string) bool {
+	keyId, encodedMac, found := strings.Cut(tag, ".")
+	if !found {
+		return false
+	}
+	key, ok := k.lookup(keyId)
+	if !ok {
+		return false
+	}
+	decodedMac, err := base64.URLEncoding.DecodeString(encodedMac)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(decodedMac, mac(key.Secret, message))
+}
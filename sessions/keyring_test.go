@@ -0,0 +1,86 @@
+package sessions
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestKeyringRotation(t *testing.T) {
+	keys := NewKeyringFromSecret("old signing secret")
+	legacy := signSessionId("session", keys)
+
+	if err := keys.Rotate(Key{Id: "2", Secret: "new signing secret"}); err != nil {
+		t.Fatalf("Rotate() error = %v", err)
+	}
+	rotated := signSessionId("session", keys)
+
+	for _, signed := range []string{legacy, rotated} {
+		got, err := VerifySessionId(signed, keys)
+		if err != nil {
+			t.Fatalf("VerifySessionId(%q) error = %v", signed, err)
+		}
+		if got != "session" {
+			t.Errorf("VerifySessionId(%q) = %q, want %q", signed, got, "session")
+		}
+	}
+
+	if err := keys.RemoveKey(""); err != nil {
+		t.Fatalf("RemoveKey() error = %v", err)
+	}
+	if _, err := VerifySessionId(legacy, keys); !errors.Is(err, ErrUnknownSigningKey) {
+		t.Errorf("VerifySessionId() after removal error = %v, want %v", err, ErrUnknownSigningKey)
+	}
+	if err := keys.RemoveKey("2"); !errors.Is(err, ErrActiveKeyRemoval) {
+		t.Errorf("RemoveKey(active) error = %v, want %v", err, ErrActiveKeyRemoval)
+	}
+}
+
+func TestVerifySessionIdRejectsTampering(t *testing.T) {
+	keys, err := NewKeyring(Key{Id: "a", Secret: "signing secret a"}, Key{Id: "b", Secret: "signing secret b"})
+	if err != nil {
+		t.Fatalf("NewKeyring() error = %v", err)
+	}
+	signed := signSessionId("session", keys)
+	_, _, signature, _ := splitSignedSessionId(signed)
+
+	tests := []struct {
+		name   string
+		signed string
+		want   error
+	}{
+		{"other session id", "other.a." + signature, ErrInvalidSessionSignature},
+		{"other key id", "session.b." + signature, ErrInvalidSessionSignature},
+		{"unknown key id", "session.c." + signature, ErrUnknownSigningKey},
+		{"too many parts", "session.a.b." + signature, ErrSignedSessionIdIncorrectLength},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := VerifySessionId(tt.signed, keys); !errors.Is(err, tt.want) {
+				t.Errorf("VerifySessionId() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewKeyringRejectsDuplicateIds(t *testing.T) {
+	_, err := NewKeyring(Key{Id: "a", Secret: "signing secret one"}, Key{Id: "a", Secret: "signing secret two"})
+	if !errors.Is(err, ErrDuplicateKeyId) {
+		t.Errorf("NewKeyring() error = %v, want %v", err, ErrDuplicateKeyId)
+	}
+}
+
+func TestKeyringRejectsShortSecrets(t *testing.T) {
+	if _, err := NewKeyring(Key{Id: "a", Secret: "short"}); !errors.Is(err, ErrShortSigningKey) {
+		t.Errorf("NewKeyring() error = %v, want %v", err, ErrShortSigningKey)
+	}
+	for _, secret := range []string{"", "short"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("NewKeyringFromSecret(%q) did not panic", secret)
+				}
+			}()
+			NewKeyringFromSecret(secret)
+		}()
+	}
+}
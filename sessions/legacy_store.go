@@ -0,0 +1,98 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The original shape of AuthStore, where only the load methods took a context and took it last. It is kept so that
+// stores written against it keep working through AdaptLegacyAuthStore while they are migrated.
+//
+// Deprecated: implement AuthStore instead.
+type LegacyAuthStore interface {
+	SaveUser(User) error
+	LoadUserByUserId(string, context.Context) (User, error)
+	LoadUserByUsername(string, context.Context) (User, error)
+
+	SaveSession(Session) error
+	LoadSessionById(string, context.Context) (Session, error)
+	DeleteSessionById(string) error
+}
+
+// Returns an AuthStore that calls through to a store implementing LegacyAuthStore. Methods the legacy store cannot
+// pass a context to return the context's error without calling the store if it is already done.
+//
+// The methods AuthStore has gained since, such as UpdateSession and ListSessionsByUserId, return an error wrapping
+// ErrNotSupported. Registering, logging in and out and Authmiddleware work, but sessions are not renewed, session
+// data is not saved, and the account and session management handlers and the Reaper fail until the store is
+// migrated.
+func AdaptLegacyAuthStore(store LegacyAuthStore) AuthStore {
+	return legacyAuthStore{legacy: store}
+}
+
+type legacyAuthStore struct {
+	legacy LegacyAuthStore
+}
+
+// returns the error for a method the legacy interface does not have
+func notSupportedByLegacyStore(method string) error {
+	return fmt.Errorf("%s on a LegacyAuthStore: %w", method, ErrNotSupported)
+}
+
+func (s legacyAuthStore) SaveUser(ctx context.Context, u User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.legacy.SaveUser(u)
+}
+
+func (s legacyAuthStore) LoadUserByUserId(ctx context.Context, id string) (User, error) {
+	return s.legacy.LoadUserByUserId(id, ctx)
+}
+
+func (s legacyAuthStore) LoadUserByUsername(ctx context.Context, username string) (User, error) {
+	return s.legacy.LoadUserByUsername(username, ctx)
+}
+
+func (s legacyAuthStore) UpdateUser(context.Context, User) error {
+	return notSupportedByLegacyStore("UpdateUser")
+}
+
+func (s legacyAuthStore) DeleteUserByUserId(context.Context, string) error {
+	return notSupportedByLegacyStore("DeleteUserByUserId")
+}
+
+func (s legacyAuthStore) SaveSession(ctx context.Context, session Session) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.legacy.SaveSession(session)
+}
+
+func (s legacyAuthStore) LoadSessionById(ctx context.Context, id string) (Session, error) {
+	return s.legacy.LoadSessionById(id, ctx)
+}
+
+func (s legacyAuthStore) UpdateSession(context.Context, Session) error {
+	return notSupportedByLegacyStore("UpdateSession")
+}
+
+func (s legacyAuthStore) DeleteSessionById(ctx context.Context, id string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return s.legacy.DeleteSessionById(id)
+}
+
+func (s legacyAuthStore) ListSessionsByUserId(context.Context, string) ([]Session, error) {
+	return nil, notSupportedByLegacyStore("ListSessionsByUserId")
+}
+
+func (s legacyAuthStore) DeleteSessionsByUserId(context.Context, string, string) error {
+	return notSupportedByLegacyStore("DeleteSessionsByUserId")
+}
+
+func (s legacyAuthStore) DeleteExpiredSessions(context.Context, time.Time, int) (int, error) {
+	return 0, notSupportedByLegacyStore("DeleteExpiredSessions")
+}
@@ -0,0 +1,147 @@
+package sessions_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/cameronmore/go-sessions/auth"
+	"github.com/cameronmore/go-sessions/auth/authtest"
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// a store written against the original six method interface
+type mapLegacyStore struct {
+	users    map[string]sessions.User
+	sessions map[string]sessions.Session
+}
+
+func newMapLegacyStore() *mapLegacyStore {
+	return &mapLegacyStore{users: make(map[string]sessions.User), sessions: make(map[string]sessions.Session)}
+}
+
+func (m *mapLegacyStore) SaveUser(u sessions.User) error {
+	m.users[u.UserId] = u
+	return nil
+}
+
+func (m *mapLegacyStore) LoadUserByUserId(id string, _ context.Context) (sessions.User, error) {
+	u, ok := m.users[id]
+	if !ok {
+		return u, sessions.ErrUserNotFound
+	}
+	return u, nil
+}
+
+func (m *mapLegacyStore) LoadUserByUsername(username string, _ context.Context) (sessions.User, error) {
+	for _, u := range m.users {
+		if u.Username == username {
+			return u, nil
+		}
+	}
+	return sessions.User{}, sessions.ErrUserNotFound
+}
+
+func (m *mapLegacyStore) SaveSession(s sessions.Session) error {
+	m.sessions[string(s.Id)] = s
+	return nil
+}
+
+func (m *mapLegacyStore) LoadSessionById(id string, _ context.Context) (sessions.Session, error) {
+	s, ok := m.sessions[id]
+	if !ok {
+		return s, sessions.ErrSessionNotFound
+	}
+	return s, nil
+}
+
+func (m *mapLegacyStore) DeleteSessionById(id string) error {
+	if _, ok := m.sessions[id]; !ok {
+		return sessions.ErrSessionNotFound
+	}
+	delete(m.sessions, id)
+	return nil
+}
+
+func TestAdaptLegacyAuthStore(t *testing.T) {
+	legacy := newMapLegacyStore()
+	store := sessions.AdaptLegacyAuthStore(legacy)
+	ctx := t.Context()
+
+	if err := store.SaveUser(ctx, sessions.User{UserId: "01", Username: "alice"}); err != nil {
+		t.Fatalf("SaveUser() error = %v", err)
+	}
+	if u, err := store.LoadUserByUsername(ctx, "alice"); err != nil || u.UserId != "01" {
+		t.Errorf("LoadUserByUsername() = %+v, %v, want user 01", u, err)
+	}
+	if err := store.SaveSession(ctx, sessions.Session{Id: "session", UserId: "01"}); err != nil {
+		t.Fatalf("SaveSession() error = %v", err)
+	}
+	if s, err := store.LoadSessionById(ctx, "session"); err != nil || s.UserId != "01" {
+		t.Errorf("LoadSessionById() = %+v, %v, want the saved session", s, err)
+	}
+	if err := store.DeleteSessionById(ctx, "session"); err != nil {
+		t.Errorf("DeleteSessionById() error = %v", err)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := store.SaveSession(cancelled, sessions.Session{Id: "late"}); !errors.Is(err, context.Canceled) {
+		t.Errorf("SaveSession() with a cancelled context error = %v, want %v", err, context.Canceled)
+	}
+	if _, ok := legacy.sessions["late"]; ok {
+		t.Error("SaveSession() with a cancelled context still called the legacy store")
+	}
+
+	unsupported := map[string]error{
+		"UpdateUser":             store.UpdateUser(ctx, sessions.User{UserId: "01"}),
+		"DeleteUserByUserId":     store.DeleteUserByUserId(ctx, "01"),
+		"UpdateSession":          store.UpdateSession(ctx, sessions.Session{Id: "session"}),
+		"DeleteSessionsByUserId": store.DeleteSessionsByUserId(ctx, "01", ""),
+	}
+	_, unsupported["ListSessionsByUserId"] = store.ListSessionsByUserId(ctx, "01")
+	_, unsupported["DeleteExpiredSessions"] = store.DeleteExpiredSessions(ctx, time.Now(), 0)
+	for method, err := range unsupported {
+		if !errors.Is(err, sessions.ErrNotSupported) || !strings.Contains(err.Error(), method) {
+			t.Errorf("%s() error = %v, want %v naming the method", method, err, sessions.ErrNotSupported)
+		}
+	}
+}
+
+func TestLegacyAuthStoreWithHandlers(t *testing.T) {
+	ac := auth.NewAuthContext(sessions.AdaptLegacyAuthStore(newMapLegacyStore()),
+		sessions.NewKeyringFromSecret("test signing secret"), time.Hour, auth.WithPasswordHasher(authtest.FastHasher()))
+	me := ac.Authmiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(h http.Handler, r *http.Request) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(http.HandlerFunc(ac.RegisterHandler), authtest.NewRequest(http.MethodPost, "/register",
+		`{"username":"alice","password":"correct horse battery"}`))
+	if w.Code != http.StatusCreated {
+		t.Fatalf("registering returned %d: %s", w.Code, w.Body)
+	}
+	cookie := authtest.FindCookie(w.Result().Cookies(), ac.Cookie.CookieName())
+
+	w = serve(http.HandlerFunc(ac.LoginHandler), authtest.NewRequest(http.MethodPost, "/login",
+		`{"username":"alice","password":"correct horse battery"}`))
+	if w.Code != http.StatusOK {
+		t.Fatalf("logging in returned %d: %s", w.Code, w.Body)
+	}
+	if w := serve(me, authtest.NewRequest(http.MethodGet, "/me", "", cookie)); w.Code != http.StatusOK {
+		t.Errorf("the middleware returned %d for a session in the legacy store: %s", w.Code, w.Body)
+	}
+
+	if w := serve(http.HandlerFunc(ac.LogoutHandler), authtest.NewRequest(http.MethodPost, "/logout", "", cookie)); w.Code != http.StatusOK {
+		t.Fatalf("logging out returned %d: %s", w.Code, w.Body)
+	}
+	if w := serve(me, authtest.NewRequest(http.MethodGet, "/me", "", cookie)); w.Code != http.StatusUnauthorized {
+		t.Errorf("the middleware returned %d after logging out, want %d", w.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,52 @@
+package sessions
+
+import (
+	"net/http"
+	"time"
+)
+
+// An Option changes where NewCookieWithSessionId, RegisterHandler, LoginHandler and LogoutHandler get the time and
+// new session ids from.
+type Option func(*issuer)
+
+// the sources used to make session cookies
+type issuer struct {
+	clock      Clock
+	sessionIds IdGenerator
+}
+
+// Sets the clock that cookie expiry times are computed from.
+func WithClock(clock Clock) Option {
+	return func(i *issuer) {
+		i.clock = clock
+	}
+}
+
+// Sets the generator for new session ids, UUIDv4 by default.
+func WithSessionIdGenerator(gen IdGenerator) Option {
+	return func(i *issuer) {
+		i.sessionIds = gen
+	}
+}
+
+// applies the options over the defaults. Nil values fall back to the defaults as well.
+func newIssuer(opts []Option) issuer {
+	var i issuer
+	for _, opt := range opts {
+		opt(&i)
+	}
+	if i.clock == nil {
+		i.clock = SystemClock{}
+	}
+	if i.sessionIds == nil {
+		i.sessionIds = UUIDv4
+	}
+	return i
+}
+
+// returns a new session id and its signed cookie, which expires d from now
+func (i issuer) newCookie(keys *Keyring, d time.Duration, cfg CookieConfig) (sessionId string, cookie *http.Cookie) {
+	sessionId = i.sessionIds()
+	cookie = cfg.cookie(signSessionId(sessionId, keys), i.clock.Now().Add(d))
+	return
+}
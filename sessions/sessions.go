@@ -2,52 +2,40 @@ package sessions
 
 import (
 	"crypto/hmac"
-	"crypto/sha256"
 	"encoding/base64"
 	"fmt"
 	"net/http"
 	"strings"
 	"time"
-
-	"github.com/google/uuid"
 )
 
-func newSessionId() string {
-	uid := uuid.New()
-	return uid.String()
-}
-
-func signSessionId(sessionId string, secret string) string {
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write([]byte(sessionId))
-	signature := mac.Sum(nil)
-	return fmt.Sprintf("%s.%s", sessionId, base64.URLEncoding.EncodeToString(signature))
-}
-
-func newCookie(signedSessionId string, d time.Duration) *http.Cookie {
-	return &http.Cookie{
-		Name:     "session_id",
-		Value:    signedSessionId,
-		Path:     "/",
-		Expires:  time.Now().Add(d),
-		HttpOnly: true,
-		Secure:   true,
-		SameSite: http.SameSiteLaxMode,
+// signs a session id with the active key of the keyring, embedding the key id when the key has one
+func signSessionId(sessionId string, keys *Keyring) string {
+	key := keys.activeKey()
+	signature := base64.URLEncoding.EncodeToString(mac(key.Secret, sessionId))
+	if key.Id == "" {
+		return fmt.Sprintf("%s.%s", sessionId, signature)
 	}
+	return fmt.Sprintf("%s.%s.%s", sessionId, key.Id, signature)
 }
 
 // Returns a new cookie and session id
-func NewCookieWithSessionId(secret string, d time.Duration) (cookie *http.Cookie, sessionId string) {
-	sessionId = newSessionId()
-	signedSessionId := signSessionId(sessionId, secret)
-	cookie = newCookie(signedSessionId, d)
+func NewCookieWithSessionId(keys *Keyring, d time.Duration, cfg CookieConfig, opts ...Option) (cookie *http.Cookie, sessionId string) {
+	sessionId, cookie = newIssuer(opts).newCookie(keys, d, cfg)
 	return
 }
 
-// verifies a session signature from a given signed string
-func VerifySessionId(requestCookieSessionId string, secret string) (string, error) {
+// Returns a freshly signed cookie for an existing session id that expires at the given time. This is used to extend
+// the lifetime of a session on the client when the session is renewed, and re-signs it with the active key.
+func RenewCookie(sessionId string, keys *Keyring, expiresAt time.Time, cfg CookieConfig) *http.Cookie {
+	return cfg.cookie(signSessionId(sessionId, keys), expiresAt)
+}
+
+// verifies a session signature from a given signed string, using whichever key in the keyring the embedded key id
+// refers to
+func VerifySessionId(requestCookieSessionId string, keys *Keyring) (string, error) {
 
-	requestSessionId, encodedSignature, err := splitSignedSessionId(requestCookieSessionId)
+	requestSessionId, keyId, encodedSignature, err := splitSignedSessionId(requestCookieSessionId)
 	if err != nil {
 		return "", err
 	}
@@ -56,9 +44,11 @@ func VerifySessionId(requestCookieSessionId string, secret string) (string, erro
 		return "", err
 	}
 
-	mac := hmac.New(sha256.New, []byte(secret))
-	mac.Write([]byte(requestSessionId))
-	expectedSignature := mac.Sum(nil)
+	key, ok := keys.lookup(keyId)
+	if !ok {
+		return "", ErrUnknownSigningKey
+	}
+	expectedSignature := mac(key.Secret, requestSessionId)
 
 	if hmac.Equal(decodedSignature, expectedSignature) {
 		return requestSessionId, nil
@@ -67,22 +57,27 @@ func VerifySessionId(requestCookieSessionId string, secret string) (string, erro
 	return "", ErrInvalidSessionSignature
 }
 
-func splitSignedSessionId(signedSessionId string) (string, string, error) {
+// splits a signed session id into the session id, key id and signature. Values without a key id are returned with
+// an empty key id.
+func splitSignedSessionId(signedSessionId string) (string, string, string, error) {
 	parts := strings.Split(signedSessionId, ".")
-	if len(parts) != 2 {
-		return "", "", ErrSignedSessionIdIncorrectLength
+	switch len(parts) {
+	case 2:
+		return parts[0], "", parts[1], nil
+	case 3:
+		return parts[0], parts[1], parts[2], nil
 	}
-	return parts[0], parts[1], nil
+	return "", "", "", ErrSignedSessionIdIncorrectLength
 }
 
 // Returns a session id if the given request contains a valid cookie, along with a helper boolean for indicating if
 // the cookie is valid (true if so)
-func VerifyRequestSessionCookie(r *http.Request, secret string) (string, bool) {
-	requestCookie, err := r.Cookie("session_id")
+func VerifyRequestSessionCookie(r *http.Request, keys *Keyring, cfg CookieConfig) (string, bool) {
+	requestCookie, err := r.Cookie(cfg.CookieName())
 	if err != nil {
 		return "", false
 	}
-	verifiedSessionId, err := VerifySessionId(requestCookie.Value, secret)
+	verifiedSessionId, err := VerifySessionId(requestCookie.Value, keys)
 	if err != nil {
 		return "", false
 	}
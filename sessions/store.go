@@ -2,6 +2,10 @@ package sessions
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
 	"time"
 )
 
@@ -17,20 +21,189 @@ func SessionIdFromString(s string) SessionId {
 	return SessionId(s)
 }
 
+// the prefix of a SessionId that stands for a session by its digest
+const digestIdPrefix = "sha256:"
+
+// Returns a SessionId that stands for a session by the digest of its id, for stores that keep only the digests. It
+// is what such stores list in ListSessionsByUserId: it can be passed to DeleteSessionById and its Digest is the
+// digest itself, but it cannot be used to load the session, since a digest must never work as a credential.
+func SessionIdFromDigest(digest string) SessionId {
+	return SessionId(digestIdPrefix + digest)
+}
+
+// Reports whether the id was made by SessionIdFromDigest.
+func (id SessionId) IsDigest() bool {
+	return strings.HasPrefix(string(id), digestIdPrefix)
+}
+
+// Returns the hex encoded SHA-256 digest of the session id. It identifies a session to its user, for example in a
+// list of their devices, without giving away the id itself, which is as good as a password. The SQL stores keep
+// only this digest.
+func (id SessionId) Digest() string {
+	if digest, ok := strings.CutPrefix(string(id), digestIdPrefix); ok {
+		return digest
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:])
+}
+
 type Session struct {
 	Id        SessionId
 	UserId    string
+	CreatedAt time.Time
 	ExpiresAt time.Time
+	// When the session was last used, updated by the middleware at most about once a minute.
+	LastSeenAt time.Time
+	// The User-Agent header and client IP address of the request that created the session.
+	UserAgent string
+	IPAddress string
+	// Values kept with the session, which handlers read and change through auth.SessionKey.
+	Data SessionData
+}
+
+// Stores users. Every method takes the context of the request it is called for first, so that stores can cancel
+// their work when the client goes away.
+type UserStore interface {
+	SaveUser(context.Context, User) error
+	LoadUserByUserId(context.Context, string) (User, error)
+	LoadUserByUsername(context.Context, string) (User, error)
+	// Replaces the username and hashed password of the user with the same UserId.
+	UpdateUser(context.Context, User) error
+	// Deletes a user. Stores that keep sessions as well delete the user's sessions along with them.
+	DeleteUserByUserId(context.Context, string) error
 }
 
+// Stores sessions. Every method takes the context of the request it is called for first, so that stores can cancel
+// their work when the client goes away.
+type SessionStore interface {
+	SaveSession(context.Context, Session) error
+	LoadSessionById(context.Context, string) (Session, error)
+	// Replaces the expiry, last seen time and data of an existing session.
+	UpdateSession(context.Context, Session) error
+	// Deletes a session given its id or the id it is listed under by ListSessionsByUserId.
+	DeleteSessionById(context.Context, string) error
+	// Returns all sessions of a user, including expired ones that have not been deleted yet. Stores that keep only
+	// digests of session ids return ids made by SessionIdFromDigest.
+	ListSessionsByUserId(context.Context, string) ([]Session, error)
+	// Deletes all sessions of a user except the one with the given session id, which may be empty to delete them
+	// all.
+	DeleteSessionsByUserId(ctx context.Context, userId string, exceptSessionId string) error
+	// Deletes up to limit sessions that expired before the given time and returns how many were deleted. A limit
+	// of zero or less deletes all of them.
+	DeleteExpiredSessions(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+// Implemented by session stores that can swap one session for another in a single step, which AuthContext uses to
+// promote a guest's session to a logged-in one.
+type SessionReplacer interface {
+	// Deletes the session with the old id, if it still exists, and saves the new session, all or nothing. Returns
+	// ErrSessionExists if the new session's id is taken.
+	ReplaceSession(ctx context.Context, oldSessionId string, s Session) error
+}
+
+// Deletes the session with the old id, if it still exists, and saves the new session in the store. Stores that
+// implement SessionReplacer do this atomically; with other stores the new session is saved first, so that a failure
+// never loses both.
+func ReplaceSession(ctx context.Context, store SessionStore, oldSessionId string, s Session) error {
+	if replacer, ok := store.(SessionReplacer); ok {
+		return replacer.ReplaceSession(ctx, oldSessionId, s)
+	}
+	if err := store.SaveSession(ctx, s); err != nil {
+		return err
+	}
+	if err := store.DeleteSessionById(ctx, oldSessionId); err != nil && !errors.Is(err, ErrSessionNotFound) {
+		return err
+	}
+	return nil
+}
+
+// Implemented by session stores that can replace the data of a session without touching its expiry and last seen
+// time, which AuthContext uses to save what a handler changed without undoing a renewal by a concurrent request.
+type SessionDataUpdater interface {
+	// Replaces the data of an existing session. Returns ErrSessionNotFound if there is none.
+	UpdateSessionData(ctx context.Context, sessionId string, data SessionData) error
+}
+
+// Replaces the data of an existing session in the store. Stores that implement SessionDataUpdater do this in a
+// single step; with other stores the session is loaded first and updated with the expiry and last seen time it was
+// stored with, so that only a renewal landing between the two is lost.
+func UpdateSessionData(ctx context.Context, store SessionStore, sessionId string, data SessionData) error {
+	if updater, ok := store.(SessionDataUpdater); ok {
+		return updater.UpdateSessionData(ctx, sessionId, data)
+	}
+	s, err := store.LoadSessionById(ctx, sessionId)
+	if err != nil {
+		return err
+	}
+	s.Data = data
+	return store.UpdateSession(ctx, s)
+}
+
+// Stores users and their sessions in one place, like the SQL stores do. Users and sessions can also be kept in
+// separate stores and combined with NewCompositeAuthStore.
+//
+// Stores written against the original interface, where only the load methods took a context and took it last, can
+// be wrapped with AdaptLegacyAuthStore.
 type AuthStore interface {
-	SaveUser(User) error
-	LoadUserByUserId(string, context.Context) (User, error)
-	LoadUserByUsername(string, context.Context) (User, error)
-	// DeleteUserByUserId(string) error
-	// UpdateUser(User) error
-
-	SaveSession(Session) error
-	LoadSessionById(string, context.Context) (Session, error)
-	DeleteSessionById(string) error
+	UserStore
+	SessionStore
+}
+
+// An AuthStore made of a separate UserStore and SessionStore, for example users in Postgres and sessions in Redis.
+type CompositeAuthStore struct {
+	UserStore
+	SessionStore
+}
+
+// Returns an AuthStore that keeps users in one store and sessions in another. Either may be a combined AuthStore
+// such as PostgresAuthStore, of which only the users or only the sessions are used.
+func NewCompositeAuthStore(users UserStore, sessions SessionStore) *CompositeAuthStore {
+	return &CompositeAuthStore{UserStore: users, SessionStore: sessions}
+}
+
+// Replaces a session in the session store, atomically if it implements SessionReplacer.
+func (c *CompositeAuthStore) ReplaceSession(ctx context.Context, oldSessionId string, s Session) error {
+	return ReplaceSession(ctx, c.SessionStore, oldSessionId, s)
+}
+
+// Replaces the data of a session in the session store, in a single step if it implements SessionDataUpdater.
+func (c *CompositeAuthStore) UpdateSessionData(ctx context.Context, sessionId string, data SessionData) error {
+	return UpdateSessionData(ctx, c.SessionStore, sessionId, data)
+}
+
+// Deletes the user's sessions from the session store and then the user from the user store.
+func (c *CompositeAuthStore) DeleteUserByUserId(ctx context.Context, id string) error {
+	if err := c.SessionStore.DeleteSessionsByUserId(ctx, id, ""); err != nil {
+		return err
+	}
+	return c.UserStore.DeleteUserByUserId(ctx, id)
+}
+
+// The login throttling state for a single key, such as a username or a client IP address.
+type LoginAttempts struct {
+	Key string
+	// The attempts left in the key's token bucket as of UpdatedAt
+	Tokens    float64
+	UpdatedAt time.Time
+	// The number of failed logins since the last successful one
+	Failures    int
+	LockedUntil time.Time
+}
+
+// Implemented by stores that can persist login throttling state, so that it can be shared between several
+// instances of an application.
+type LoginAttemptStore interface {
+	// Returns the state for a key, or a zero LoginAttempts with only Key set if nothing is stored for it.
+	LoadLoginAttempts(context.Context, string) (LoginAttempts, error)
+	// Inserts or replaces the state for a key.
+	SaveLoginAttempts(context.Context, LoginAttempts) error
+	DeleteLoginAttempts(context.Context, string) error
+}
+
+// Implemented by LoginAttemptStores that can delete stale throttling state in bulk, which the auth package's Reaper
+// uses so that keys which never log in successfully don't pile up.
+type LoginAttemptSweeper interface {
+	// Deletes up to limit keys whose last attempt and lockout both ended before the given time, or all of them if
+	// limit is zero, and returns how many were deleted.
+	DeleteLoginAttemptsBefore(ctx context.Context, before time.Time, limit int) (int, error)
 }
@@ -0,0 +1,478 @@
+// Package storetest checks that a sessions.AuthStore implementation behaves the way AuthContext expects: that it
+// saves and loads users and sessions along with their data faithfully, returns the sessions package's error sentinels, keeps usernames and
+// ids unique, deletes expired sessions and is safe for concurrent use.
+//
+// Call Run from a test in the store's package with a function returning a new, empty store:
+//
+//	func TestMyStore(t *testing.T) {
+//		storetest.Run(t, func(t *testing.T) sessions.AuthStore {
+//			return NewMyStore(...)
+//		})
+//	}
+package storetest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cameronmore/go-sessions/sessions"
+)
+
+// Returns a new, empty store for a single subtest. Use t.Cleanup to release anything it holds.
+type Factory func(t *testing.T) sessions.AuthStore
+
+// Runs the whole suite against the stores returned by newStore, each part as a subtest with a store of its own.
+// Stores that also implement sessions.LoginAttemptStore have that checked too.
+func Run(t *testing.T, newStore Factory) {
+	t.Run("Users", func(t *testing.T) { testUsers(t, newStore(t)) })
+	t.Run("UserUniqueness", func(t *testing.T) { testUserUniqueness(t, newStore(t)) })
+	t.Run("Sessions", func(t *testing.T) { testSessions(t, newStore(t)) })
+	t.Run("SessionData", func(t *testing.T) { testSessionData(t, newStore(t)) })
+	t.Run("GuestSessions", func(t *testing.T) { testGuestSessions(t, newStore(t)) })
+	t.Run("SessionsByUser", func(t *testing.T) { testSessionsByUser(t, newStore(t)) })
+	t.Run("DeleteUser", func(t *testing.T) { testDeleteUser(t, newStore(t)) })
+	t.Run("Expiry", func(t *testing.T) { testExpiry(t, newStore(t)) })
+	t.Run("Concurrency", func(t *testing.T) { testConcurrency(t, newStore(t)) })
+	t.Run("LoginAttempts", func(t *testing.T) {
+		store, ok := newStore(t).(sessions.LoginAttemptStore)
+		if !ok {
+			t.Skip("the store does not implement sessions.LoginAttemptStore")
+		}
+		testLoginAttempts(t, store)
+	})
+}
+
+// Times are compared at whole seconds, which is what the SQL stores keep.
+func now() time.Time {
+	return time.Now().Truncate(time.Second)
+}
+
+func newSession(id string, userId string, created time.Time) sessions.Session {
+	return sessions.Session{
+		Id:         sessions.SessionId(id),
+		UserId:     userId,
+		CreatedAt:  created,
+		ExpiresAt:  created.Add(time.Hour),
+		LastSeenAt: created,
+		UserAgent:  "storetest/1.0",
+		IPAddress:  "192.0.2.1",
+	}
+}
+
+func mustSaveUser(t *testing.T, store sessions.AuthStore, u sessions.User) {
+	t.Helper()
+	if err := store.SaveUser(t.Context(), u); err != nil {
+		t.Fatalf("SaveUser(%+v) returned %v", u, err)
+	}
+}
+
+func mustSaveSession(t *testing.T, store sessions.AuthStore, s sessions.Session) {
+	t.Helper()
+	if err := store.SaveSession(t.Context(), s); err != nil {
+		t.Fatalf("SaveSession(%s) returned %v", s.Id, err)
+	}
+}
+
+func wantErr(t *testing.T, call string, err error, want error) {
+	t.Helper()
+	if !errors.Is(err, want) {
+		t.Errorf("%s returned %v, want %v", call, err, want)
+	}
+}
+
+func sameSession(got sessions.Session, want sessions.Session) bool {
+	return got.Id == want.Id && got.UserId == want.UserId && got.CreatedAt.Equal(want.CreatedAt) &&
+		got.ExpiresAt.Equal(want.ExpiresAt) && got.LastSeenAt.Equal(want.LastSeenAt) &&
+		got.UserAgent == want.UserAgent && got.IPAddress == want.IPAddress && got.Data.Equal(want.Data)
+}
+
+func testUsers(t *testing.T, store sessions.AuthStore) {
+	ctx := t.Context()
+	alice := sessions.User{UserId: "user-1", Username: "alice", HashedPassword: "$argon2id$hash"}
+	mustSaveUser(t, store, alice)
+
+	if u, err := store.LoadUserByUserId(ctx, alice.UserId); err != nil || u != alice {
+		t.Errorf("LoadUserByUserId() = %+v, %v, want %+v", u, err, alice)
+	}
+	if u, err := store.LoadUserByUsername(ctx, alice.Username); err != nil || u != alice {
+		t.Errorf("LoadUserByUsername() = %+v, %v, want %+v", u, err, alice)
+	}
+	_, err := store.LoadUserByUserId(ctx, "missing")
+	wantErr(t, "LoadUserByUserId() for a missing user", err, sessions.ErrUserNotFound)
+	_, err = store.LoadUserByUsername(ctx, "missing")
+	wantErr(t, "LoadUserByUsername() for a missing user", err, sessions.ErrUserNotFound)
+
+	updated := sessions.User{UserId: alice.UserId, Username: "alicia", HashedPassword: "$argon2id$new"}
+	if err := store.UpdateUser(ctx, updated); err != nil {
+		t.Fatalf("UpdateUser() returned %v", err)
+	}
+	if u, err := store.LoadUserByUsername(ctx, "alicia"); err != nil || u != updated {
+		t.Errorf("LoadUserByUsername() after an update = %+v, %v, want %+v", u, err, updated)
+	}
+	_, err = store.LoadUserByUsername(ctx, "alice")
+	wantErr(t, "LoadUserByUsername() for the old username", err, sessions.ErrUserNotFound)
+	err = store.UpdateUser(ctx, sessions.User{UserId: "missing", Username: "carol", HashedPassword: "x"})
+	wantErr(t, "UpdateUser() for a missing user", err, sessions.ErrUserNotFound)
+}
+
+func testUserUniqueness(t *testing.T, store sessions.AuthStore) {
+	ctx := t.Context()
+	mustSaveUser(t, store, sessions.User{UserId: "user-1", Username: "alice", HashedPassword: "x"})
+	mustSaveUser(t, store, sessions.User{UserId: "user-2", Username: "bob", HashedPassword: "x"})
+
+	err := store.SaveUser(ctx, sessions.User{UserId: "user-1", Username: "carol", HashedPassword: "x"})
+	wantErr(t, "SaveUser() with a taken user id", err, sessions.ErrUserExists)
+	err = store.SaveUser(ctx, sessions.User{UserId: "user-3", Username: "alice", HashedPassword: "x"})
+	wantErr(t, "SaveUser() with a taken username", err, sessions.ErrUserExists)
+	err = store.UpdateUser(ctx, sessions.User{UserId: "user-2", Username: "alice", HashedPassword: "x"})
+	wantErr(t, "UpdateUser() to a taken username", err, sessions.ErrUserExists)
+
+	// a rejected save or update must leave the existing users alone
+	if u, err := store.LoadUserByUsername(ctx, "alice"); err != nil || u.UserId != "user-1" {
+		t.Errorf("LoadUserByUsername(alice) = %+v, %v, want user-1", u, err)
+	}
+	if u, err := store.LoadUserByUsername(ctx, "bob"); err != nil || u.UserId != "user-2" {
+		t.Errorf("LoadUserByUsername(bob) = %+v, %v, want user-2", u, err)
+	}
+	_, err = store.LoadUserByUsername(ctx, "carol")
+	wantErr(t, "LoadUserByUsername() for a rejected username", err, sessions.ErrUserNotFound)
+}
+
+func testSessions(t *testing.T, store sessions.AuthStore) {
+	ctx := t.Context()
+	mustSaveUser(t, store, sessions.User{UserId: "user-1", Username: "alice", HashedPassword: "x"})
+	s := newSession("session-1", "user-1", now())
+	mustSaveSession(t, store, s)
+
+	if got, err := store.LoadSessionById(ctx, string(s.Id)); err != nil || !sameSession(got, s) {
+		t.Errorf("LoadSessionById() = %+v, %v, want %+v", got, err, s)
+	}
+	_, err := store.LoadSessionById(ctx, "missing")
+	wantErr(t, "LoadSessionById() for a missing session", err, sessions.ErrSessionNotFound)
+	err = store.SaveSession(ctx, s)
+	wantErr(t, "SaveSession() with a taken id", err, sessions.ErrSessionExists)
+
+	// only the expiry, last seen time and data change on an update
+	renewed := s
+	renewed.ExpiresAt = s.ExpiresAt.Add(time.Hour)
+	renewed.LastSeenAt = s.LastSeenAt.Add(time.Minute)
+	renewed.UserAgent = "changed"
+	if err := store.UpdateSession(ctx, renewed); err != nil {
+		t.Fatalf("UpdateSession() returned %v", err)
+	}
+	want := s
+	want.ExpiresAt = renewed.ExpiresAt
+	want.LastSeenAt = renewed.LastSeenAt
+	if got, err := store.LoadSessionById(ctx, string(s.Id)); err != nil || !sameSession(got, want) {
+		t.Errorf("LoadSessionById() after an update = %+v, %v, want %+v", got, err, want)
+	}
+	err = store.UpdateSession(ctx, newSession("missing", "user-1", now()))
+	wantErr(t, "UpdateSession() for a missing session", err, sessions.ErrSessionNotFound)
+
+	if err := store.DeleteSessionById(ctx, string(s.Id)); err != nil {
+		t.Fatalf("DeleteSessionById() returned %v", err)
+	}
+	_, err = store.LoadSessionById(ctx, string(s.Id))
+	wantErr(t, "LoadSessionById() for a deleted session", err, sessions.ErrSessionNotFound)
+	err = store.DeleteSessionById(ctx, string(s.Id))
+	wantErr(t, "DeleteSessionById() for a deleted session", err, sessions.ErrSessionNotFound)
+}
+
+func testSessionData(t *testing.T, store sessions.AuthStore) {
+	ctx := t.Context()
+	mustSaveUser(t, store, sessions.User{UserId: "user-1", Username: "alice", HashedPassword: "x"})
+	s := newSession("session-1", "user-1", now())
+	s.Data = sessions.SessionData{
+		"cart":   json.RawMessage(`{"items":[{"sku":"A-1","quantity":2}],"note":"gift \u00e9"}`),
+		"tenant": json.RawMessage(`"acme"`),
+	}
+	mustSaveSession(t, store, s)
+	got, err := store.LoadSessionById(ctx, string(s.Id))
+	if err != nil || !sameSession(got, s) {
+		t.Fatalf("LoadSessionById() = %+v, %v, want %+v", got, err, s)
+	}
+
+	// the loaded data belongs to the caller
+	got.Data["tenant"] = json.RawMessage(`"other"`)
+	if again, _ := store.LoadSessionById(ctx, string(s.Id)); !again.Data.Equal(s.Data) {
+		t.Errorf("changing loaded data changed the stored session to %v", again.Data)
+	}
+
+	s.Data = sessions.SessionData{"flash": json.RawMessage(`["Saved"]`)}
+	if err := store.UpdateSession(ctx, s); err != nil {
+		t.Fatalf("UpdateSession() returned %v", err)
+	}
+	if got, err := store.LoadSessionById(ctx, string(s.Id)); err != nil || !sameSession(got, s) {
+		t.Errorf("LoadSessionById() after changing the data = %+v, %v, want %+v", got, err, s)
+	}
+	if list, err := store.ListSessionsByUserId(ctx, "user-1"); err != nil || len(list) != 1 || !list[0].Data.Equal(s.Data) {
+		t.Errorf("ListSessionsByUserId() = %+v, %v, want the session with its data", list, err)
+	}
+
+	// replacing only the data leaves the expiry and last seen time alone, whether or not the store has its own way
+	// of doing so
+	data := sessions.SessionData{"tenant": json.RawMessage(`"acme"`)}
+	if err := sessions.UpdateSessionData(ctx, store, string(s.Id), data); err != nil {
+		t.Fatalf("UpdateSessionData() returned %v", err)
+	}
+	want := s
+	want.Data = data
+	if got, err := store.LoadSessionById(ctx, string(s.Id)); err != nil || !sameSession(got, want) {
+		t.Errorf("LoadSessionById() after UpdateSessionData() = %+v, %v, want %+v", got, err, want)
+	}
+	err = sessions.UpdateSessionData(ctx, store, "missing", data)
+	wantErr(t, "UpdateSessionData() for a missing session", err, sessions.ErrSessionNotFound)
+
+	s.Data = nil
+	if err := store.UpdateSession(ctx, s); err != nil {
+		t.Fatalf("UpdateSession() returned %v", err)
+	}
+	if got, err := store.LoadSessionById(ctx, string(s.Id)); err != nil || len(got.Data) != 0 {
+		t.Errorf("LoadSessionById() after clearing the data = %+v, %v, want no data", got, err)
+	}
+}
+
+func testGuestSessions(t *testing.T, store sessions.AuthStore) {
+	ctx := t.Context()
+	mustSaveUser(t, store, sessions.User{UserId: "user-1", Username: "alice", HashedPassword: "x"})
+	guest := newSession("guest-1", "", now())
+	guest.Data = sessions.SessionData{"cart": json.RawMessage(`["A-1"]`)}
+	mustSaveSession(t, store, guest)
+	if got, err := store.LoadSessionById(ctx, string(guest.Id)); err != nil || !sameSession(got, guest) {
+		t.Fatalf("LoadSessionById() = %+v, %v, want %+v", got, err, guest)
+	}
+
+	// logging in replaces the guest session with one for the user under a new id
+	promoted := newSession("session-1", "user-1", now())
+	promoted.Data = guest.Data
+	if err := sessions.ReplaceSession(ctx, store, string(guest.Id), promoted); err != nil {
+		t.Fatalf("ReplaceSession() returned %v", err)
+	}
+	_, err := store.LoadSessionById(ctx, string(guest.Id))
+	wantErr(t, "LoadSessionById() of the replaced session", err, sessions.ErrSessionNotFound)
+	if got, err := store.LoadSessionById(ctx, string(promoted.Id)); err != nil || !sameSession(got, promoted) {
+		t.Errorf("LoadSessionById() of the new session = %+v, %v, want %+v", got, err, promoted)
+	}
+	if list, err := store.ListSessionsByUserId(ctx, "user-1"); err != nil || len(list) != 1 || list[0].Id.Digest() != promoted.Id.Digest() {
+		t.Errorf("ListSessionsByUserId() = %+v, %v, want only the new session", list, err)
+	}
+
+	// a new id that is taken fails the swap and leaves the guest session alone
+	guest3 := newSession("guest-3", "", now())
+	mustSaveSession(t, store, guest3)
+	err = sessions.ReplaceSession(ctx, store, string(guest3.Id), newSession("session-1", "user-1", now()))
+	wantErr(t, "ReplaceSession() to a taken id", err, sessions.ErrSessionExists)
+	if _, err := store.LoadSessionById(ctx, string(guest3.Id)); err != nil {
+		t.Errorf("LoadSessionById() of the guest session after a failed swap returned %v", err)
+	}
+
+	// a guest session that is already gone is no reason to fail a login
+	if err := sessions.ReplaceSession(ctx, store, "guest-2", newSession("session-2", "user-1", now())); err != nil {
+		t.Errorf("ReplaceSession() of a missing session returned %v", err)
+	}
+}
+
+func testSessionsByUser(t *testing.T, store sessions.AuthStore) {
+	ctx := t.Context()
+	mustSaveUser(t, store, sessions.User{UserId: "user-1", Username: "alice", HashedPassword: "x"})
+	mustSaveUser(t, store, sessions.User{UserId: "user-2", Username: "bob", HashedPassword: "x"})
+	start := now()
+	// saved out of order to check that they are listed oldest first
+	for _, i := range []int{2, 0, 1} {
+		mustSaveSession(t, store, newSession(fmt.Sprintf("alice-%d", i), "user-1", start.Add(time.Duration(i)*time.Second)))
+	}
+	mustSaveSession(t, store, newSession("bob-0", "user-2", start))
+
+	list, err := store.ListSessionsByUserId(ctx, "user-1")
+	if err != nil {
+		t.Fatalf("ListSessionsByUserId() returned %v", err)
+	}
+	// stores that keep only digests of session ids list the digests, so sessions are told apart by their digest
+	var digests, want []string
+	for i, s := range list {
+		digests = append(digests, s.Id.Digest())
+		want = append(want, sessions.SessionId(fmt.Sprintf("alice-%d", i)).Digest())
+	}
+	if fmt.Sprint(digests) != fmt.Sprint(want) {
+		t.Errorf("ListSessionsByUserId() = %v, want alice-0, alice-1 and alice-2 in that order", list)
+	}
+	if list, err := store.ListSessionsByUserId(ctx, "nobody"); err != nil || len(list) != 0 {
+		t.Errorf("ListSessionsByUserId() for a user without sessions = %v, %v, want none", list, err)
+	}
+
+	if err := store.DeleteSessionsByUserId(ctx, "user-1", "alice-1"); err != nil {
+		t.Fatalf("DeleteSessionsByUserId() returned %v", err)
+	}
+	list, _ = store.ListSessionsByUserId(ctx, "user-1")
+	if len(list) != 1 || list[0].Id.Digest() != sessions.SessionId("alice-1").Digest() {
+		t.Fatalf("after deleting all but alice-1, ListSessionsByUserId() = %v", list)
+	}
+	// a listed id can be used to delete its session, which is how users revoke their sessions
+	if err := store.DeleteSessionById(ctx, string(list[0].Id)); err != nil {
+		t.Errorf("DeleteSessionById() with a listed id returned %v", err)
+	}
+	_, err = store.LoadSessionById(ctx, "alice-1")
+	wantErr(t, "LoadSessionById() for a session deleted by its listed id", err, sessions.ErrSessionNotFound)
+	mustSaveSession(t, store, newSession("alice-1", "user-1", start))
+	if _, err := store.LoadSessionById(ctx, "bob-0"); err != nil {
+		t.Errorf("deleting alice's sessions deleted bob's too: %v", err)
+	}
+	if err := store.DeleteSessionsByUserId(ctx, "user-1", ""); err != nil {
+		t.Fatalf("DeleteSessionsByUserId() without an exception returned %v", err)
+	}
+	if list, _ := store.ListSessionsByUserId(ctx, "user-1"); len(list) != 0 {
+		t.Errorf("after deleting all sessions, ListSessionsByUserId() = %v", list)
+	}
+}
+
+func testDeleteUser(t *testing.T, store sessions.AuthStore) {
+	ctx := t.Context()
+	mustSaveUser(t, store, sessions.User{UserId: "user-1", Username: "alice", HashedPassword: "x"})
+	mustSaveUser(t, store, sessions.User{UserId: "user-2", Username: "bob", HashedPassword: "x"})
+	mustSaveSession(t, store, newSession("alice-0", "user-1", now()))
+	mustSaveSession(t, store, newSession("bob-0", "user-2", now()))
+
+	if err := store.DeleteUserByUserId(ctx, "user-1"); err != nil {
+		t.Fatalf("DeleteUserByUserId() returned %v", err)
+	}
+	_, err := store.LoadUserByUserId(ctx, "user-1")
+	wantErr(t, "LoadUserByUserId() for a deleted user", err, sessions.ErrUserNotFound)
+	_, err = store.LoadUserByUsername(ctx, "alice")
+	wantErr(t, "LoadUserByUsername() for a deleted user", err, sessions.ErrUserNotFound)
+	_, err = store.LoadSessionById(ctx, "alice-0")
+	wantErr(t, "LoadSessionById() for a deleted user's session", err, sessions.ErrSessionNotFound)
+	if _, err := store.LoadSessionById(ctx, "bob-0"); err != nil {
+		t.Errorf("deleting alice deleted bob's session too: %v", err)
+	}
+	err = store.DeleteUserByUserId(ctx, "user-1")
+	wantErr(t, "DeleteUserByUserId() for a deleted user", err, sessions.ErrUserNotFound)
+
+	// the username is free again
+	mustSaveUser(t, store, sessions.User{UserId: "user-3", Username: "alice", HashedPassword: "x"})
+}
+
+// Stores may delete expired sessions themselves, like Redis does, so the checks only require that expired sessions
+// are gone once DeleteExpiredSessions has been called until it comes back short, and that nothing else is.
+func testExpiry(t *testing.T, store sessions.AuthStore) {
+	ctx := t.Context()
+	mustSaveUser(t, store, sessions.User{UserId: "user-1", Username: "alice", HashedPassword: "x"})
+	start := now()
+	for i := range 3 {
+		s := newSession(fmt.Sprintf("expired-%d", i), "user-1", start.Add(-2*time.Hour))
+		mustSaveSession(t, store, s)
+	}
+	active := newSession("active", "user-1", start)
+	mustSaveSession(t, store, active)
+
+	for {
+		n, err := store.DeleteExpiredSessions(ctx, start, 2)
+		if err != nil {
+			t.Fatalf("DeleteExpiredSessions() returned %v", err)
+		}
+		if n > 2 {
+			t.Fatalf("DeleteExpiredSessions() with a limit of 2 deleted %d sessions", n)
+		}
+		if n < 2 {
+			break
+		}
+	}
+	for i := range 3 {
+		_, err := store.LoadSessionById(ctx, fmt.Sprintf("expired-%d", i))
+		wantErr(t, "LoadSessionById() for a reaped session", err, sessions.ErrSessionNotFound)
+	}
+	if got, err := store.LoadSessionById(ctx, "active"); err != nil || !sameSession(got, active) {
+		t.Errorf("LoadSessionById() for an unexpired session = %+v, %v, want %+v", got, err, active)
+	}
+	if list, _ := store.ListSessionsByUserId(ctx, "user-1"); len(list) != 1 {
+		t.Errorf("ListSessionsByUserId() after reaping returned %d sessions, want 1", len(list))
+	}
+	if n, err := store.DeleteExpiredSessions(ctx, start, 0); err != nil || n != 0 {
+		t.Errorf("DeleteExpiredSessions() with nothing left to delete = %d, %v, want 0", n, err)
+	}
+}
+
+func testConcurrency(t *testing.T, store sessions.AuthStore) {
+	ctx := t.Context()
+	const workers = 8
+
+	// exactly one of several users racing for the same username gets it
+	var wg sync.WaitGroup
+	errs := make([]error, workers)
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = store.SaveUser(ctx, sessions.User{UserId: fmt.Sprintf("user-%d", i), Username: "alice", HashedPassword: "x"})
+		}()
+	}
+	wg.Wait()
+	saved := 0
+	for _, err := range errs {
+		switch {
+		case err == nil:
+			saved++
+		case !errors.Is(err, sessions.ErrUserExists):
+			t.Errorf("a SaveUser() that lost the race returned %v, want %v", err, sessions.ErrUserExists)
+		}
+	}
+	if saved != 1 {
+		t.Fatalf("%d concurrent SaveUser() calls for the same username succeeded, want 1", saved)
+	}
+	u, err := store.LoadUserByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatalf("LoadUserByUsername() returned %v", err)
+	}
+
+	// concurrent logins and renewals of the same user don't lose sessions
+	for i := range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s := newSession(fmt.Sprintf("session-%d", i), u.UserId, now())
+			if err := store.SaveSession(ctx, s); err != nil {
+				t.Errorf("SaveSession() returned %v", err)
+				return
+			}
+			s.LastSeenAt = s.LastSeenAt.Add(time.Second)
+			if err := store.UpdateSession(ctx, s); err != nil {
+				t.Errorf("UpdateSession() returned %v", err)
+			}
+			if _, err := store.LoadUserByUserId(ctx, u.UserId); err != nil {
+				t.Errorf("LoadUserByUserId() returned %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if list, err := store.ListSessionsByUserId(ctx, u.UserId); err != nil || len(list) != workers {
+		t.Errorf("ListSessionsByUserId() after concurrent logins returned %d sessions, %v, want %d", len(list), err, workers)
+	}
+}
+
+func testLoginAttempts(t *testing.T, store sessions.LoginAttemptStore) {
+	ctx := t.Context()
+	if a, err := store.LoadLoginAttempts(ctx, "user:alice"); err != nil || a != (sessions.LoginAttempts{Key: "user:alice"}) {
+		t.Errorf("LoadLoginAttempts() for a new key = %+v, %v, want an empty state", a, err)
+	}
+	a := sessions.LoginAttempts{Key: "user:alice", Tokens: 2.5, UpdatedAt: now(), Failures: 3, LockedUntil: now().Add(time.Minute)}
+	if err := store.SaveLoginAttempts(ctx, a); err != nil {
+		t.Fatalf("SaveLoginAttempts() returned %v", err)
+	}
+	a.Failures = 4
+	if err := store.SaveLoginAttempts(ctx, a); err != nil {
+		t.Fatalf("SaveLoginAttempts() over an existing state returned %v", err)
+	}
+	got, err := store.LoadLoginAttempts(ctx, "user:alice")
+	if err != nil || got.Tokens != a.Tokens || !got.UpdatedAt.Equal(a.UpdatedAt) || got.Failures != 4 || !got.LockedUntil.Equal(a.LockedUntil) {
+		t.Errorf("LoadLoginAttempts() = %+v, %v, want %+v", got, err, a)
+	}
+	if err := store.DeleteLoginAttempts(ctx, "user:alice"); err != nil {
+		t.Fatalf("DeleteLoginAttempts() returned %v", err)
+	}
+	if got, _ := store.LoadLoginAttempts(ctx, "user:alice"); got.Failures != 0 {
+		t.Errorf("LoadLoginAttempts() after a delete = %+v, want an empty state", got)
+	}
+}